@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 
 	"github.com/gorilla/mux"
 	"github.com/mattermost/mattermost/server/public/model"
@@ -11,6 +12,8 @@ import (
 	"github.com/pkg/errors"
 )
 
+const pluginURL = "/plugins/com.mattermost.plugin-expense-bot"
+
 // ServeHTTP demonstrates a plugin that handles HTTP requests by greeting the world.
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
 	router := mux.NewRouter()
@@ -20,7 +23,18 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 
 	apiRouter := router.PathPrefix("/api/").Subrouter()
 
+	apiRouter.HandleFunc("/expenses/{id}/cancel", p.safeHandler(p.CancelExpense)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/expenses/{id}/edit", p.safeHandler(p.EditExpense)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/expenses/{id}/resubmit", p.safeHandler(p.ResubmitExpense)).Methods(http.MethodPost)
 	apiRouter.HandleFunc("/expenses/{id}/{state}", p.safeHandler(p.UpdateExpense)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/export", p.safeHandler(p.ExportExpenses)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/drafts/category", p.safeHandler(p.SelectDraftCategory)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/drafts/confirm", p.safeHandler(p.ConfirmDraft)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/drafts/edit", p.safeHandler(p.EditDraft)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/drafts/cancel", p.safeHandler(p.CancelDraft)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/dialogs/expense", p.safeHandler(p.SubmitExpenseDialog)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/dialogs/reject", p.safeHandler(p.SubmitRejectDialog)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/dialogs/paid", p.safeHandler(p.SubmitPaidDialog)).Methods(http.MethodPost)
 
 	router.ServeHTTP(w, r)
 }
@@ -64,25 +78,316 @@ func (p *Plugin) UpdateExpense(w http.ResponseWriter, r *http.Request) {
 	p.API.LogInfo("Updating expense", "id", expenseID, "state", state)
 	p.API.LogInfo(fmt.Sprintf("post_id: %s channel_id: %s", request.PostId, request.ChannelId))
 
-	expense, err := p.kvstore.GetExpense(expenseID)
+	if !isExpenseState(state) {
+		http.Error(w, "unknown state", http.StatusBadRequest)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-ID")
+	if state == ExpenseStateRejected || state == ExpenseStatePaid {
+		// Ask for the reason or the payment first, the dialog submission changes the state
+		openDialog := p.openRejectDialog
+		if state == ExpenseStatePaid {
+			openDialog = p.openPaidDialog
+		}
+		message, err := openDialog(request.TriggerId, userID, expenseID, request.PostId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.writeActionResponse(w, &model.PostActionIntegrationResponse{EphemeralText: message})
+		return
+	}
+
+	message, err := p.changeExpenseState(userID, expenseID, state, request.PostId, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.writeActionResponse(w, &model.PostActionIntegrationResponse{EphemeralText: message})
+}
+
+func (p *Plugin) writeActionResponse(w http.ResponseWriter, response *model.PostActionIntegrationResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err)
+	}
+}
+
+func (p *Plugin) SelectDraftCategory(w http.ResponseWriter, r *http.Request) {
+	var request *model.PostActionIntegrationRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode PostActionIntegrationRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-ID")
+	name, _ := request.Context["category"].(string)
+
+	response := &model.PostActionIntegrationResponse{}
+	draft, err := p.getActiveDraft(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	category := p.getConfiguration().getCategory(name)
+	switch {
+	case draft == nil || draft.State != DraftStateAskCategory:
+		response.EphemeralText = "This question has already been answered."
+	case category == nil:
+		response.EphemeralText = "This category no longer exists, please pick another one."
+	default:
+		if err = p.setDraftCategory(draft, category); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response.Update = &model.Post{
+			Message: fmt.Sprintf("**What kind of expense is it?** %s", category.Name),
+			Props:   model.StringInterface{},
+		}
+	}
+	p.writeActionResponse(w, response)
+}
+
+func (p *Plugin) ConfirmDraft(w http.ResponseWriter, r *http.Request) {
+	var request *model.PostActionIntegrationRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode PostActionIntegrationRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	response := &model.PostActionIntegrationResponse{}
+	draft, err := p.getActiveDraft(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if draft == nil || draft.State != DraftStateConfirm {
+		response.EphemeralText = "This expense claim was already submitted or changed since."
+		p.writeActionResponse(w, response)
+		return
+	}
+	expense, err := p.submitDraft(userID, draft)
+	if err != nil {
+		if errors.Is(err, errDraftChanged) {
+			response.EphemeralText = "This expense claim was already submitted or changed since."
+		}
+		p.writeActionResponse(w, response)
+		return
+	}
+	message, err := p.formatExpense(expense)
+	if err != nil {
+		// The claim is saved, only the review post keeps its buttons
+		p.API.LogError("failed to format expense", "err", err.Error())
+		p.writeActionResponse(w, response)
+		return
+	}
+	response.Update = &model.Post{
+		Message: "**Your expense claim**\n\n" + message,
+		Props:   model.StringInterface{},
+	}
+	p.writeActionResponse(w, response)
+}
+
+func (p *Plugin) CancelDraft(w http.ResponseWriter, r *http.Request) {
+	var request *model.PostActionIntegrationRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode PostActionIntegrationRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-ID")
+
+	response := &model.PostActionIntegrationResponse{}
+	draft, err := p.getActiveDraft(userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	expense.State = state
-	if err = p.kvstore.SaveExpense(expense); err != nil {
+	if draft == nil || draft.State != DraftStateConfirm {
+		response.EphemeralText = "This expense claim was already submitted or changed since."
+		p.writeActionResponse(w, response)
+		return
+	}
+	ok, err := p.kvstore.DeleteDraftIfUnchanged(draft)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		response.EphemeralText = "This expense claim was already submitted or changed since."
+		p.writeActionResponse(w, response)
+		return
+	}
+	response.Update = &model.Post{
+		Message: discardedMessage(draft),
+		Props:   model.StringInterface{},
 	}
-	if err = p.updateUser(expense); err != nil {
+	p.writeActionResponse(w, response)
+}
+
+func (p *Plugin) EditDraft(w http.ResponseWriter, r *http.Request) {
+	var request *model.PostActionIntegrationRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode PostActionIntegrationRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	userID := r.Header.Get("Mattermost-User-ID")
+	field, _ := request.Context["selected_option"].(string)
+
+	response := &model.PostActionIntegrationResponse{}
+	draft, err := p.getActiveDraft(userID)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if err = p.updateChannel(request, expense); err != nil {
+	if draft == nil || draft.State != DraftStateConfirm {
+		response.EphemeralText = "This expense claim was already submitted or changed since."
+	} else if response.EphemeralText, err = p.editField(draft, field); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if _, err = w.Write([]byte("OK")); err != nil {
+	p.writeActionResponse(w, response)
+}
+
+func (p *Plugin) SubmitExpenseDialog(w http.ResponseWriter, r *http.Request) {
+	var request *model.SubmitDialogRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode SubmitDialogRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if request.UserId != r.Header.Get("Mattermost-User-ID") {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	response, err := p.submitExpenseDialog(request)
+	if err != nil {
+		p.API.LogError("failed to submit expense dialog", "err", err.Error())
+		response = &model.SubmitDialogResponse{Error: "System error, please try again."}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(response); err != nil {
 		p.API.LogError("Failed to write response", "error", err)
+	}
+}
+
+func (p *Plugin) SubmitRejectDialog(w http.ResponseWriter, r *http.Request) {
+	var request *model.SubmitDialogRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode SubmitDialogRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if request.UserId != r.Header.Get("Mattermost-User-ID") {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	response, err := p.submitRejectDialog(request)
+	if err != nil {
+		p.API.LogError("failed to submit reject dialog", "err", err.Error())
+		response = &model.SubmitDialogResponse{Error: "System error, please try again."}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err)
+	}
+}
+
+func (p *Plugin) SubmitPaidDialog(w http.ResponseWriter, r *http.Request) {
+	var request *model.SubmitDialogRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode SubmitDialogRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if request.UserId != r.Header.Get("Mattermost-User-ID") {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	response, err := p.submitPaidDialog(request)
+	if err != nil {
+		p.API.LogError("failed to submit paid dialog", "err", err.Error())
+		response = &model.SubmitDialogResponse{Error: "System error, please try again."}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogError("Failed to write response", "error", err)
+	}
+}
+
+func (p *Plugin) ResubmitExpense(w http.ResponseWriter, r *http.Request) {
+	var request *model.PostActionIntegrationRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode PostActionIntegrationRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	message, err := p.resubmitExpense(r.Header.Get("Mattermost-User-ID"), mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.writeActionResponse(w, &model.PostActionIntegrationResponse{EphemeralText: message})
+}
+
+// CancelExpense withdraws an expense from the pinned DM of the submitter. The DM is not an
+// approval post, so it is updated as the user post only.
+func (p *Plugin) CancelExpense(w http.ResponseWriter, r *http.Request) {
+	var request *model.PostActionIntegrationRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode PostActionIntegrationRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	message, err := p.changeExpenseState(r.Header.Get("Mattermost-User-ID"), mux.Vars(r)["id"], ExpenseStateCancelled, "", nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.writeActionResponse(w, &model.PostActionIntegrationResponse{EphemeralText: message})
+}
+
+func (p *Plugin) EditExpense(w http.ResponseWriter, r *http.Request) {
+	var request *model.PostActionIntegrationRequest
+	decodeErr := json.NewDecoder(r.Body).Decode(&request)
+	if decodeErr != nil || request == nil {
+		p.API.LogWarn("failed to decode PostActionIntegrationRequest")
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	message, err := p.editExpense(r.Header.Get("Mattermost-User-ID"), mux.Vars(r)["id"])
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	p.writeActionResponse(w, &model.PostActionIntegrationResponse{EphemeralText: message})
 }
 
 func (p *Plugin) updateUser(expense *Expense) error {
@@ -95,6 +400,10 @@ func (p *Plugin) updateUser(expense *Expense) error {
 		return errors.Wrap(err, "failed to format expense")
 	}
 	post.Message = message
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{Actions: userActions(expense)}})
+	if p.getConfiguration().UnpinClosedClaims && isFinalState(expense.State) {
+		post.IsPinned = false
+	}
 	_, appErr = p.API.UpdatePost(post)
 	if appErr != nil {
 		return errors.Wrap(appErr, "failed to update post")
@@ -102,25 +411,45 @@ func (p *Plugin) updateUser(expense *Expense) error {
 	return nil
 }
 
-func (p *Plugin) updateChannel(request *model.PostActionIntegrationRequest, expense *Expense) error {
-	user, appError := p.API.GetUser(expense.UserID)
-	if appError != nil {
-		return errors.Wrap(appError, "failed to get user")
+// updateChannel updates the channel posts of the expense, including the post a button was clicked
+// in for expenses posted before their posts were recorded.
+func (p *Plugin) updateChannel(expense *Expense, requestPostID string) error {
+	postIDs := []string{expense.ChannelPostID, expense.FinancePostID}
+	if !slices.Contains(postIDs, requestPostID) {
+		postIDs = append(postIDs, requestPostID)
+	}
+	for _, postID := range postIDs {
+		if postID == "" {
+			continue
+		}
+		if err := p.updateExpensePost(expense, postID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) updateExpensePost(expense *Expense, postID string) error {
+	title, err := p.channelTitle(expense)
+	if err != nil {
+		return err
 	}
 	message, err := p.formatExpense(expense)
 	if err != nil {
 		return errors.Wrap(err, "failed to format expense")
 	}
-
-	post := &model.Post{
-		Id:        request.PostId,
-		UserId:    p.botID,
-		ChannelId: request.ChannelId,
-		Message:   fmt.Sprintf("**Expense claim from %s %s**\n\n%s", user.FirstName, user.LastName, message),
-		FileIds:   expense.FileIDs,
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get post")
 	}
-	if _, appError = p.API.UpdatePost(post); appError != nil {
-		return errors.Wrap(appError, "failed to update post")
+	post.Message = fmt.Sprintf("%s\n\n%s", title, message)
+	post.FileIds = expense.FileIDs
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		AuthorName: "",
+		Actions:    p.expenseActions(expense, postID),
+	}})
+	if _, appErr = p.API.UpdatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to update post")
 	}
 	return nil
 }
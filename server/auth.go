@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseMemberList splits a configured list of usernames and group names, separated by commas or
+// whitespace. A leading @ is ignored.
+func parseMemberList(list string) []string {
+	fields := strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		names = append(names, strings.ToLower(strings.TrimPrefix(field, "@")))
+	}
+	return names
+}
+
+// isMember tells whether the user is in the list, by username or through one of their groups.
+func (p *Plugin) isMember(userID string, names []string) (bool, error) {
+	if len(names) == 0 {
+		return false, nil
+	}
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to get user")
+	}
+	candidates := []string{strings.ToLower(user.Username)}
+	groups, appErr := p.API.GetGroupsForUser(userID)
+	if appErr != nil {
+		// Groups need a license, fall back to usernames only
+		p.API.LogWarn("failed to get groups", "user_id", userID, "err", appErr.Error())
+	}
+	for _, group := range groups {
+		if group.Name != nil {
+			candidates = append(candidates, strings.ToLower(*group.Name))
+		}
+	}
+	for _, candidate := range candidates {
+		for _, name := range names {
+			if candidate == name {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// authorizePayment returns why the user may not create payment batches, or an empty string when
+// they may. Payers may, or anyone when no payers are configured.
+func (p *Plugin) authorizePayment(userID string) (string, error) {
+	ok, err := p.hasRole(userID, parseMemberList(p.getConfiguration().Payers))
+	if err != nil || ok {
+		return "", err
+	}
+	return "Only payers can create payment batches.", nil
+}
+
+// canExportAll tells whether the user may export the expense claims of everyone. Approvers, payers
+// and the approvers of categories may. As the export holds everyone's bank account, nobody may when
+// none are configured.
+func (p *Plugin) canExportAll(userID string) (bool, error) {
+	config := p.getConfiguration()
+	members := append(parseMemberList(config.Approvers), parseMemberList(config.Payers)...)
+	for _, category := range config.categories {
+		members = append(members, parseMemberList(category.Approver)...)
+	}
+	return p.isMember(userID, members)
+}
+
+// hasRole tells whether the user holds the role given by its members. Anyone does when the role has
+// no members.
+func (p *Plugin) hasRole(userID string, members []string) (bool, error) {
+	if len(members) == 0 {
+		return true, nil
+	}
+	return p.isMember(userID, members)
+}
+
+// authorizeTransition returns why the user may not move the expense to the state, or an empty
+// string when they may. Approvers may approve and reject, payers may pay and reject. The approver
+// of the category counts as approver. When a role has nobody configured, anyone holds it, except
+// the submitter themselves. Anyone may reject only when there are neither approvers nor payers. Only the submitter may cancel.
+func (p *Plugin) authorizeTransition(userID string, expense *Expense, to string) (string, error) {
+	if to == ExpenseStateCancelled {
+		if userID != expense.UserID {
+			return "Only the submitter can cancel an expense claim.", nil
+		}
+		return "", nil
+	}
+	if userID == expense.UserID {
+		return "You can't approve or pay your own expense claim.", nil
+	}
+	config := p.getConfiguration()
+	approvers := parseMemberList(config.Approvers)
+	if category := config.getCategory(expense.Category); category != nil && category.Approver != "" {
+		approvers = append(approvers, parseMemberList(category.Approver)...)
+	}
+	payers := parseMemberList(config.Payers)
+
+	var ok bool
+	var err error
+	var message string
+	switch to {
+	case ExpenseStateApproved:
+		ok, err = p.hasRole(userID, approvers)
+		message = "Only approvers can approve an expense claim."
+	case ExpenseStatePaid:
+		ok, err = p.hasRole(userID, payers)
+		message = "Only payers can mark an expense claim as paid."
+	case ExpenseStateRejected:
+		// Either role may reject, so anyone may only when neither is configured
+		ok, err = p.hasRole(userID, append(approvers, payers...))
+		message = "Only approvers and payers can reject an expense claim."
+	default:
+		return "You can't change the state of an expense claim to " + to + ".", nil
+	}
+	if err != nil || ok {
+		return "", err
+	}
+	return message, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+// newAuthPlugin returns a plugin with the configuration, whose users have their ID as username and
+// are in no group.
+func newAuthPlugin(config *configuration) *Plugin {
+	api := &plugintest.API{}
+	api.On("GetUser", mock.AnythingOfType("string")).Return(func(userID string) *model.User {
+		return &model.User{Id: userID, Username: userID}
+	}, nil).Maybe()
+	api.On("GetGroupsForUser", mock.AnythingOfType("string")).Return([]*model.Group{}, nil).Maybe()
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(config)
+	return p
+}
+
+func TestAuthorizeTransition(t *testing.T) {
+	both := &configuration{Approvers: "approver", Payers: "payer"}
+	approversOnly := &configuration{Approvers: "approver"}
+	payersOnly := &configuration{Payers: "payer"}
+	categoryOnly := &configuration{categories: []*Category{{Name: "Travel", Approver: "@traveller"}}}
+	for name, tc := range map[string]struct {
+		config   *configuration
+		userID   string
+		category string
+		to       string
+		allowed  bool
+	}{
+		"approver approves":                  {config: both, userID: "approver", to: ExpenseStateApproved, allowed: true},
+		"payer can't approve":                {config: both, userID: "payer", to: ExpenseStateApproved},
+		"payer pays":                         {config: both, userID: "payer", to: ExpenseStatePaid, allowed: true},
+		"approver can't pay":                 {config: both, userID: "approver", to: ExpenseStatePaid},
+		"approver rejects":                   {config: both, userID: "approver", to: ExpenseStateRejected, allowed: true},
+		"payer rejects":                      {config: both, userID: "payer", to: ExpenseStateRejected, allowed: true},
+		"other can't reject":                 {config: both, userID: "other", to: ExpenseStateRejected},
+		"anyone approves without roles":      {config: &configuration{}, userID: "other", to: ExpenseStateApproved, allowed: true},
+		"anyone pays without payers":         {config: approversOnly, userID: "other", to: ExpenseStatePaid, allowed: true},
+		"approvers only still restricts":     {config: approversOnly, userID: "other", to: ExpenseStateApproved},
+		"anyone approves without approvers":  {config: payersOnly, userID: "other", to: ExpenseStateApproved, allowed: true},
+		"payers only restrict rejecting":     {config: payersOnly, userID: "other", to: ExpenseStateRejected},
+		"other can't reject without payers":  {config: approversOnly, userID: "other", to: ExpenseStateRejected},
+		"approver rejects without payers":    {config: approversOnly, userID: "approver", to: ExpenseStateRejected, allowed: true},
+		"anyone rejects without roles":       {config: &configuration{}, userID: "other", to: ExpenseStateRejected, allowed: true},
+		"category approver approves":         {config: categoryOnly, userID: "traveller", category: "travel", to: ExpenseStateApproved, allowed: true},
+		"category approver restricts":        {config: categoryOnly, userID: "other", category: "Travel", to: ExpenseStateApproved},
+		"category approver only for its own": {config: categoryOnly, userID: "other", category: "Meals", to: ExpenseStateApproved, allowed: true},
+		"no self approval":                   {config: both, userID: "submitter", to: ExpenseStateApproved},
+		"no self approval without roles":     {config: &configuration{}, userID: "submitter", to: ExpenseStateApproved},
+		"no self payment":                    {config: &configuration{Payers: "submitter"}, userID: "submitter", to: ExpenseStatePaid},
+		"submitter cancels":                  {config: both, userID: "submitter", to: ExpenseStateCancelled, allowed: true},
+		"approver can't cancel":              {config: both, userID: "approver", to: ExpenseStateCancelled},
+		"unknown state":                      {config: &configuration{}, userID: "other", to: ExpenseStateSubmitted},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := newAuthPlugin(tc.config)
+			expense := &Expense{UserID: "submitter", Category: tc.category}
+			message, err := p.authorizeTransition(tc.userID, expense, tc.to)
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if allowed := message == ""; allowed != tc.allowed {
+				t.Logf("expected allowed %v, got message %q", tc.allowed, message)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestAuthorizePayment(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config  *configuration
+		userID  string
+		allowed bool
+	}{
+		"payer":                       {config: &configuration{Approvers: "approver", Payers: "payer"}, userID: "payer", allowed: true},
+		"approver":                    {config: &configuration{Approvers: "approver", Payers: "payer"}, userID: "approver"},
+		"anyone without payers":       {config: &configuration{Approvers: "approver"}, userID: "other", allowed: true},
+		"anyone without any role set": {config: &configuration{}, userID: "other", allowed: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			message, err := newAuthPlugin(tc.config).authorizePayment(tc.userID)
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if allowed := message == ""; allowed != tc.allowed {
+				t.Logf("expected allowed %v, got message %q", tc.allowed, message)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestCanExportAll(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config  *configuration
+		userID  string
+		allowed bool
+	}{
+		"approver":          {config: &configuration{Approvers: "approver"}, userID: "approver", allowed: true},
+		"payer":             {config: &configuration{Payers: "payer"}, userID: "payer", allowed: true},
+		"category approver": {config: &configuration{categories: []*Category{{Name: "Travel", Approver: "traveller"}}}, userID: "traveller", allowed: true},
+		"other":             {config: &configuration{Approvers: "approver", Payers: "payer"}, userID: "other"},
+		"no roles":          {config: &configuration{}, userID: "other"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			allowed, err := newAuthPlugin(tc.config).canExportAll(tc.userID)
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if allowed != tc.allowed {
+				t.Logf("expected allowed %v, got %v", tc.allowed, allowed)
+				t.Fail()
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Category is an expense category as configured by the administrator, with the rules that apply
+// to claims in it.
+type Category struct {
+	Name string `json:"name"`
+
+	// ReceiptRequired requires at least one receipt for claims in this category.
+	ReceiptRequired bool `json:"receipt_required"`
+
+	// MaxAmount is the maximum total of a claim, in the reimbursement currency. Empty means no
+	// maximum.
+	MaxAmount string `json:"max_amount"`
+
+	// ChannelID is the channel claims in this category are posted to instead of the default one.
+	ChannelID string `json:"channel_id"`
+
+	// Approver is the username of the person handling claims in this category, who is mentioned in
+	// the approval post.
+	Approver string `json:"approver"`
+
+	maxAmount *Money
+}
+
+// parseCategories parses the JSON list of categories from the configuration.
+func parseCategories(data string, currency string) ([]*Category, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var categories []*Category
+	if err := json.Unmarshal([]byte(data), &categories); err != nil {
+		return nil, errors.Wrap(err, "failed to decode categories")
+	}
+	names := map[string]bool{}
+	for _, category := range categories {
+		category.Name = strings.TrimSpace(category.Name)
+		if category.Name == "" {
+			return nil, errors.New("category without name")
+		}
+		if names[strings.ToLower(category.Name)] {
+			return nil, errors.Errorf("duplicate category %s", category.Name)
+		}
+		names[strings.ToLower(category.Name)] = true
+		if category.MaxAmount != "" {
+			maxAmount, err := parseMoney(category.MaxAmount, currency)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid maximum amount for category %s", category.Name)
+			}
+			if maxAmount.Currency != currency {
+				return nil, errors.Errorf("maximum amount for category %s must be in %s", category.Name, currency)
+			}
+			category.maxAmount = &maxAmount
+		}
+	}
+	return categories, nil
+}
+
+// getCategory returns the configured category with the given name, ignoring case, or nil.
+func (c *configuration) getCategory(name string) *Category {
+	for _, category := range c.categories {
+		if strings.EqualFold(category.Name, strings.TrimSpace(name)) {
+			return category
+		}
+	}
+	return nil
+}
+
+// checkAmount returns an error message when total exceeds the maximum of the category.
+func (c *Category) checkAmount(total Money) string {
+	if c == nil || c.maxAmount == nil || total.Value <= c.maxAmount.Value {
+		return ""
+	}
+	return fmt.Sprintf("Claims for **%s** can be at most **%s**, this one is **%s**.", c.Name, c.maxAmount, total)
+}
+
+// receiptRequired tells whether a claim in the category needs a receipt. Claims without category
+// always need one.
+func (c *Category) receiptRequired() bool {
+	return c == nil || c.ReceiptRequired
+}
+
+// checkRules returns why the expense breaks the rules of the category, telling the user what to
+// change, or an empty string.
+func (c *Category) checkRules(expense *Expense) string {
+	if problem := c.checkAmount(expense.Amount); problem != "" {
+		return problem + " Type ```edit items``` to change the items."
+	}
+	if len(expense.FileIDs) == 0 && c.receiptRequired() {
+		return "This claim needs at least one receipt. Type ```edit files``` to add one."
+	}
+	return ""
+}
+
+// sendCategoryQuestion asks the user to pick a category with a button per category.
+func (p *Plugin) sendCategoryQuestion(userID string) {
+	categories := p.getConfiguration().categories
+	actions := make([]*model.PostAction, 0, len(categories))
+	for _, category := range categories {
+		actions = append(actions, &model.PostAction{
+			Id:    "category" + model.NewId(),
+			Name:  category.Name,
+			Type:  model.PostActionTypeButton,
+			Style: "primary",
+			Integration: &model.PostActionIntegration{
+				URL:     pluginURL + "/api/drafts/category",
+				Context: map[string]any{"category": category.Name},
+			},
+		})
+	}
+	post := &model.Post{Message: "**What kind of expense is it?** Pick a category, or type its name."}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{Actions: actions}})
+	p.sendPostDM(userID, post)
+}
+
+// setDraftCategory stores the category on the draft and moves on to the next step.
+func (p *Plugin) setDraftCategory(draft *Draft, category *Category) error {
+	draft.Data["category"] = category.Name
+	_ = p.sendDM(draft.UserID, fmt.Sprintf("Category **%s** it is.", category.Name))
+	return p.moveTo(draft, p.nextStep(DraftStateAskCategory))
+}
+
+func categoryNames(categories []*Category) string {
+	names := make([]string, 0, len(categories))
+	for _, category := range categories {
+		names = append(names, category.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseCategories(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input       string
+		expected    []string
+		expectedMax map[string]int64
+		expectedErr bool
+	}{
+		"empty":              {input: "  "},
+		"names":              {input: `[{"name": " Travel "}, {"name": "Meals"}]`, expected: []string{"Travel", "Meals"}},
+		"maximum":            {input: `[{"name": "Meals", "max_amount": "50"}]`, expected: []string{"Meals"}, expectedMax: map[string]int64{"Meals": 5000}},
+		"maximum in EUR":     {input: `[{"name": "Meals", "max_amount": "EUR 1.000,50"}]`, expected: []string{"Meals"}, expectedMax: map[string]int64{"Meals": 100050}},
+		"invalid json":       {input: `[{"name": "Meals"`, expectedErr: true},
+		"missing name":       {input: `[{"name": " "}]`, expectedErr: true},
+		"duplicate name":     {input: `[{"name": "Meals"}, {"name": "meals"}]`, expectedErr: true},
+		"invalid maximum":    {input: `[{"name": "Meals", "max_amount": "lots"}]`, expectedErr: true},
+		"maximum in USD":     {input: `[{"name": "Meals", "max_amount": "50 USD"}]`, expectedErr: true},
+		"zero maximum":       {input: `[{"name": "Meals", "max_amount": "0"}]`, expectedErr: true},
+		"not a list of maps": {input: `["Meals"]`, expectedErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			categories, err := parseCategories(tc.input, "EUR")
+			if tc.expectedErr {
+				if err == nil {
+					t.Logf("expected error, got %v", categories)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if len(categories) != len(tc.expected) {
+				t.Logf("expected %v, got %d categories", tc.expected, len(categories))
+				t.FailNow()
+			}
+			for i, category := range categories {
+				if category.Name != tc.expected[i] {
+					t.Logf("expected %s, got %s", tc.expected[i], category.Name)
+					t.Fail()
+				}
+				expectedMax, ok := tc.expectedMax[category.Name]
+				if ok != (category.maxAmount != nil) || (ok && category.maxAmount.Value != expectedMax) {
+					t.Logf("expected maximum %d for %s, got %v", expectedMax, category.Name, category.maxAmount)
+					t.Fail()
+				}
+			}
+		})
+	}
+}
+
+func TestCategoryRules(t *testing.T) {
+	limited := &Category{Name: "Meals", ReceiptRequired: true, maxAmount: &Money{Value: 5000, Currency: "EUR"}}
+	unlimited := &Category{Name: "Travel"}
+	for name, tc := range map[string]struct {
+		category        *Category
+		total           int64
+		expectedProblem bool
+		expectedReceipt bool
+	}{
+		"within maximum":   {category: limited, total: 5000, expectedReceipt: true},
+		"over maximum":     {category: limited, total: 5001, expectedProblem: true, expectedReceipt: true},
+		"without maximum":  {category: unlimited, total: 1000000},
+		"without category": {total: 1000000, expectedReceipt: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if problem := tc.category.checkAmount(Money{Value: tc.total, Currency: "EUR"}); (problem != "") != tc.expectedProblem {
+				t.Logf("expected problem %v, got %q", tc.expectedProblem, problem)
+				t.Fail()
+			}
+			if required := tc.category.receiptRequired(); required != tc.expectedReceipt {
+				t.Logf("expected receipt required %v, got %v", tc.expectedReceipt, required)
+				t.Fail()
+			}
+			expense := &Expense{Amount: Money{Value: tc.total, Currency: "EUR"}, FileIDs: []string{"receipt"}}
+			if problem := tc.category.checkRules(expense); (problem != "") != tc.expectedProblem {
+				t.Logf("expected rules problem %v, got %q", tc.expectedProblem, problem)
+				t.Fail()
+			}
+			expense.FileIDs = nil
+			if problem := tc.category.checkRules(expense); (problem != "") != (tc.expectedProblem || tc.expectedReceipt) {
+				t.Logf("expected rules problem without receipt %v, got %q", tc.expectedProblem || tc.expectedReceipt, problem)
+				t.Fail()
+			}
+		})
+	}
+}
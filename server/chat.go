@@ -2,25 +2,37 @@ package main
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/almerlucke/go-iban/iban"
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
 )
 
 const (
 	DraftStateAskName        = "ask_name"
 	DraftStateAskAccount     = "ask_account"
-	DraftStateAskAmount      = "ask_amount"
+	DraftStateAskAmount      = "ask_amount" // replaced by DraftStateAskItems, kept for drafts in progress
+	DraftStateAskItems       = "ask_items"
+	DraftStateAskCategory    = "ask_category"
 	DraftStateAskDescription = "ask_description"
 	DraftStateAskFile        = "ask_file"
 	DraftStateAskDefaults    = "ask_defaults"
+	DraftStateConfirm        = "confirm"
 	ExpenseStateSubmitted    = "Submitted"
+	ExpenseStateApproved     = "Approved"
 	ExpenseStatePaid         = "Paid"
 	ExpenseStateRejected     = "Rejected"
+	ExpenseStateCancelled    = "Cancelled"
 )
 
+const askItemsMessage = "**What did you spend?** Add one item per message as ```amount; description; category```, e.g. ```12.50; Lunch with customer; meals```. Description and category are optional. If you paid in another currency, add the currency code (e.g. ```12.50 USD```), and feel free to attach the receipt to the message.\n\nType ```done``` when all items are added."
+
+const askFileMessage = "**Upload the invoice or a picture of the receipt.**\n\nYou can drag 'n' drop a file into the chat window, or use the paperclip in the bottom right corner.\n\nIf you have multiple receipts, upload them all, in one message or several. Type ```done``` when you're finished."
+
 func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 	if post.UserId == p.botID {
 		return // bot own messages
@@ -33,7 +45,7 @@ func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 		return
 	}
 
-	draft, err := p.kvstore.GetDraft(post.UserId)
+	draft, err := p.getActiveDraft(post.UserId)
 	if err != nil {
 		p.API.LogError("failed to get draft", "err", err.Error())
 	}
@@ -41,38 +53,14 @@ func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 
 	if draft == nil {
 		if normalizeCmd(msg) == "expense" {
-			_ = p.sendDM(post.UserId, "Let's start the expense, shall we? If you change your mind, type ```reset``` and it will all be over.")
-			var userDefaults *UserDefaults
-			userDefaults, err = p.kvstore.GetUserDefaults(post.UserId)
-			if err != nil {
-				p.API.LogError("failed to get user defaults", "err", err.Error())
-			}
-			if userDefaults != nil {
-				draft = &Draft{
-					UserID: post.UserId,
-					State:  DraftStateAskDefaults,
-					Data:   map[string]string{},
-				}
-				err = p.kvstore.SaveDraft(post.UserId, draft)
-				if err != nil {
-					_ = p.sendDM(post.UserId, "System error, please try again")
-				}
-				_ = p.sendDM(post.UserId, fmt.Sprintf("Last time you used account **%s** and name **%s**. Do you want to use them again? (y[es]/n[o])", userDefaults.Account, userDefaults.Name))
-				return
-			}
-			draft = &Draft{
-				UserID: post.UserId,
-				State:  DraftStateAskAccount,
-				Data:   map[string]string{},
-			}
-			err = p.kvstore.SaveDraft(post.UserId, draft)
-			if err != nil {
-				_ = p.sendDM(post.UserId, "System error, please try again")
-			}
-			_ = p.sendDM(post.UserId, "**What is your IBAN?**")
+			p.startExpense(post.UserId)
 			return
 		}
-		_ = p.sendDM(post.UserId, "Hi! I'm ExpenseBot, I'll help you submit an expense. Type ```expense``` to start a new expense.")
+		if fields := strings.Fields(msg); len(fields) > 0 && normalizeCmd(fields[0]) == "list" {
+			_ = p.sendDM(post.UserId, p.listExpenses(post.UserId, fields[1:]))
+			return
+		}
+		_ = p.sendDM(post.UserId, "Hi! I'm ExpenseBot, I'll help you submit an expense. Type ```expense``` to start a new expense or ```list``` to see your claims, or use `/expense` from any channel.")
 		return
 	} else if normalizeCmd(msg) == "reset" {
 		if err = p.kvstore.DeleteDraft(post.UserId); err != nil {
@@ -81,6 +69,16 @@ func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 		_ = p.sendDM(post.UserId, "Type ```expense``` to start a new expense.")
 		return
 	}
+	if message, handled, navErr := p.navigate(draft, msg); handled {
+		if navErr != nil {
+			p.API.LogError("failed to save draft", "err", navErr.Error())
+			message = "System error, please try again or type ```reset``` to stop the expense."
+		}
+		if message != "" {
+			_ = p.sendDM(post.UserId, message)
+		}
+		return
+	}
 	switch draft.State {
 	case DraftStateAskAccount:
 		var account *iban.IBAN
@@ -90,67 +88,157 @@ func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 			return
 		}
 		draft.Data["iban"] = account.PrintCode
-		draft.State = DraftStateAskName
-		if err = p.kvstore.SaveDraft(post.UserId, draft); err != nil {
+		if err = p.moveTo(draft, p.nextStep(draft.State)); err != nil {
 			p.API.LogError("failed to save draft", "err", err.Error())
 			_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
 			return
 		}
-		_ = p.sendDM(post.UserId, "**In what name is the account held?**")
 
 	case DraftStateAskName:
 		draft.Data["name"] = msg
-		draft.State = DraftStateAskAmount
-		if err = p.kvstore.SaveDraft(post.UserId, draft); err != nil {
+		if err = p.moveTo(draft, p.nextStep(draft.State)); err != nil {
+			p.API.LogError("failed to save draft", "err", err.Error())
+			_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
+			return
+		}
+
+	case DraftStateAskCategory:
+		category := p.getConfiguration().getCategory(msg)
+		if category == nil {
+			_ = p.sendDM(post.UserId, "I don't know that category. Pick one of the buttons above, or type its name.")
+			return
+		}
+		if err = p.setDraftCategory(draft, category); err != nil {
 			p.API.LogError("failed to save draft", "err", err.Error())
 			_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
 			return
 		}
-		_ = p.sendDM(post.UserId, "**What is the amount of the expense?** (e.g. 100.00)\n\nIf you combine multiple receipts, fill in the total amount.")
 
-	case DraftStateAskAmount:
-		draft.Data["amount"] = msg
-		draft.State = DraftStateAskDescription
+	case DraftStateAskItems, DraftStateAskAmount:
+		cmd := normalizeCmd(msg)
+		switch {
+		case cmd == "done":
+			if len(draft.Items) == 0 {
+				_ = p.sendDM(post.UserId, "Add at least one item before typing ```done```.")
+				return
+			}
+			category := p.getConfiguration().getCategory(draft.Data["category"])
+			if problem := category.checkAmount(sumItems(draft.Items, p.getConfiguration().reimbursementCurrency())); problem != "" {
+				_ = p.sendDM(post.UserId, problem+" Type ```remove <number>``` to remove an item.")
+				return
+			}
+			if err = p.moveTo(draft, p.nextStep(DraftStateAskItems)); err != nil {
+				p.API.LogError("failed to save draft", "err", err.Error())
+				_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
+			}
+			return
+		case strings.HasPrefix(cmd, "remove"):
+			index, convErr := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(cmd, "remove")))
+			if convErr != nil || index < 1 || index > len(draft.Items) {
+				_ = p.sendDM(post.UserId, fmt.Sprintf("There is no item with that number. Type ```remove <number>``` with a number from 1 to %d.", len(draft.Items)))
+				return
+			}
+			draft.Items = slices.Delete(draft.Items, index-1, index)
+		default:
+			var item *LineItem
+			if item, err = p.parseLineItem(msg); err != nil {
+				_ = p.sendDM(post.UserId, fmt.Sprintf("Sorry, I don't understand that item (%s). Please try again, e.g. ```12.50; Lunch with customer; meals``` or ```100 USD; Train ticket```.", err.Error()))
+				return
+			}
+			if item.Category == "" {
+				item.Category = draft.Data["category"]
+			} else if categories := p.getConfiguration().categories; len(categories) > 0 {
+				category := p.getConfiguration().getCategory(item.Category)
+				if category == nil {
+					_ = p.sendDM(post.UserId, fmt.Sprintf("I don't know the category **%s**. Please use one of: %s.", item.Category, categoryNames(categories)))
+					return
+				}
+				item.Category = category.Name
+			}
+			if len(post.FileIds) > 0 {
+				item.FileID = post.FileIds[0]
+			}
+			for _, fileID := range post.FileIds {
+				if !slices.Contains(draft.FileIDs, fileID) {
+					draft.FileIDs = append(draft.FileIDs, fileID)
+				}
+			}
+			draft.Items = append(draft.Items, *item)
+		}
+		draft.State = DraftStateAskItems
 		if err = p.kvstore.SaveDraft(post.UserId, draft); err != nil {
 			p.API.LogError("failed to save draft", "err", err.Error())
 			_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
 			return
 		}
-		_ = p.sendDM(post.UserId, "**In a few words, describe the expense.**")
+		if len(draft.Items) == 0 {
+			_ = p.sendDM(post.UserId, "No items left. Add an item, e.g. ```12.50; Lunch with customer; meals```.")
+			return
+		}
+		var items string
+		if items, err = p.formatItems(draft.Items, p.getConfiguration().reimbursementCurrency()); err != nil {
+			p.API.LogError("failed to format items", "err", err.Error())
+		}
+		_ = p.sendDM(post.UserId, items+"\nAdd another item, type ```remove <number>``` to remove one, or ```done``` when you're finished.")
 
 	case DraftStateAskDescription:
 		draft.Data["description"] = msg
-		draft.State = DraftStateAskFile
-		if err = p.kvstore.SaveDraft(post.UserId, draft); err != nil {
+		if err = p.moveTo(draft, p.nextStep(draft.State)); err != nil {
 			p.API.LogError("failed to save draft", "err", err.Error())
 			_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
 			return
 		}
-		_ = p.sendDM(post.UserId, "**Upload the invoice or a picture of the receipt.**\n\nYou can drag 'n' drop a file into the chat window, or use the paperclip in the bottom right corner.\n\nIf you have multiple receipts, take a single picture of all the receipts.")
 
 	case DraftStateAskFile:
-		if len(post.FileIds) != 1 {
-			_ = p.sendDM(post.UserId, "Submit a single file.")
+		for _, fileID := range post.FileIds {
+			if !slices.Contains(draft.FileIDs, fileID) {
+				draft.FileIDs = append(draft.FileIDs, fileID)
+			}
+		}
+		if normalizeCmd(msg) != "done" {
+			if len(post.FileIds) == 0 {
+				_ = p.sendDM(post.UserId, "Upload a file, or type ```done``` when you've uploaded all receipts.")
+				return
+			}
+			if err = p.kvstore.SaveDraft(post.UserId, draft); err != nil {
+				p.API.LogError("failed to save draft", "err", err.Error())
+				_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
+				return
+			}
+			_ = p.sendDM(post.UserId, fmt.Sprintf("Got it, that's %d file(s). Upload more, or type ```done``` when you're finished.", len(draft.FileIDs)))
 			return
 		}
-		draft.Data["file"] = post.FileIds[0]
-		if err = p.createExpense(post.UserId, draft); err != nil {
-			p.API.LogError("failed to create expense", "err", err.Error())
-			_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
+		if len(draft.FileIDs) == 0 && p.getConfiguration().getCategory(draft.Data["category"]).receiptRequired() {
+			_ = p.sendDM(post.UserId, "Upload at least one file before typing ```done```.")
 			return
 		}
-		if err = p.kvstore.SaveUserDefaults(&UserDefaults{
-			UserID:  post.UserId,
-			Account: draft.Data["iban"],
-			Name:    draft.Data["name"],
-		}); err != nil {
-			p.API.LogError("failed to save user defaults", "err", err.Error())
+		if err = p.moveTo(draft, p.nextStep(draft.State)); err != nil {
+			p.API.LogError("failed to save draft", "err", err.Error())
+			_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
+			return
 		}
-		if err = p.kvstore.DeleteDraft(post.UserId); err != nil {
-			p.API.LogError("failed to delete draft", "err", err.Error())
+
+	case DraftStateConfirm:
+		switch normalizeCmd(msg) {
+		case "confirm", "submit", "yes", "y":
+			if _, err = p.submitDraft(post.UserId, draft); errors.Is(err, errDraftChanged) {
+				_ = p.sendDM(post.UserId, "This expense claim was already submitted or changed since.")
+			}
+		case "cancel":
+			var ok bool
+			if ok, err = p.kvstore.DeleteDraftIfUnchanged(draft); err != nil {
+				p.API.LogError("failed to delete draft", "err", err.Error())
+				_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
+				return
+			}
+			if !ok {
+				_ = p.sendDM(post.UserId, "This expense claim was already submitted or changed since.")
+				return
+			}
+			_ = p.sendDM(post.UserId, discardedMessage(draft))
+		default:
+			_ = p.sendDM(post.UserId, "Type ```submit``` to submit your expense claim, ```edit <field>``` to change something, or ```cancel``` to discard it.")
 		}
-		_ = p.sendDM(post.UserId, "**Expense saved! :tada:**")
-		_ = p.sendDM(post.UserId, "Type ```expense``` to start a new expense")
 
 	case DraftStateAskDefaults:
 		draft, err = p.kvstore.GetDraft(post.UserId)
@@ -170,45 +258,145 @@ func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 			}
 			draft.Data["iban"] = userDefaults.Account
 			draft.Data["name"] = userDefaults.Name
-			draft.State = DraftStateAskAmount
-			if err = p.kvstore.SaveDraft(post.UserId, draft); err != nil {
+			_ = p.sendDM(post.UserId, "Amazing, look at us being efficient! I will fill that in for you, let's continue with the expense itself.")
+			if err = p.moveTo(draft, p.nextStep(DraftStateAskName)); err != nil {
 				p.API.LogError("failed to save draft", "err", err.Error())
 				_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
 				return
 			}
-			_ = p.sendDM(post.UserId, "Amazing, look at us being efficient! I will fill that in for you, let's start with the amount.")
-			_ = p.sendDM(post.UserId, "**What is the amount of the expense?** (e.g. 100.00)\n\nIf you combine multiple receipts, fill in the total amount.")
 		case "n", "no":
-			draft.State = DraftStateAskAccount
-			err = p.kvstore.SaveDraft(post.UserId, draft)
-			if err != nil {
+			_ = p.sendDM(post.UserId, "No problem, let's start from the beginning.")
+			if err = p.moveTo(draft, DraftStateAskAccount); err != nil {
 				p.API.LogError("failed to save draft", "err", err.Error())
 				_ = p.sendDM(post.UserId, "System error, please try again or type ```reset``` to stop the expense.")
 			}
-			_ = p.sendDM(post.UserId, "No problem, let's start from the beginning.")
-			_ = p.sendDM(post.UserId, "**What is your IBAN?**")
 		default:
 			_ = p.sendDM(post.UserId, "Please answer with yes or no. Just the first letter is enough.")
 		}
 	}
 }
 
+// errDraftChanged is returned when submitting a draft that was submitted or changed since it was
+// read.
+var errDraftChanged = errors.New("draft was submitted or changed since")
+
+// submitDraft creates or updates the expense of the draft and tells the user. The draft is taken
+// out of the KV store first, so submitting it twice at once saves it once. It returns the saved
+// expense, or an error when the draft was not submitted, after telling the user why unless it is
+// errDraftChanged.
+func (p *Plugin) submitDraft(userID string, draft *Draft) (*Expense, error) {
+	// The category may have changed since the items and files were checked
+	checked, err := p.expenseFromDraft(draft)
+	if err != nil {
+		p.API.LogError("failed to get expense from draft", "err", err.Error())
+		_ = p.sendDM(userID, "System error, please try again or type ```reset``` to stop the expense.")
+		return nil, err
+	}
+	if problem := p.getConfiguration().getCategory(checked.Category).checkRules(checked); problem != "" {
+		_ = p.sendDM(userID, problem)
+		return nil, errors.New(problem)
+	}
+
+	ok, err := p.kvstore.DeleteDraftIfUnchanged(draft)
+	if err != nil {
+		p.API.LogError("failed to delete draft", "err", err.Error())
+		_ = p.sendDM(userID, "System error, please try again or type ```reset``` to stop the expense.")
+		return nil, err
+	}
+	if !ok {
+		return nil, errDraftChanged
+	}
+	var expense *Expense
+	if draft.ExpenseID != "" {
+		var message string
+		expense, message, err = p.updateExpenseFromDraft(draft)
+		if err != nil {
+			p.API.LogError("failed to update expense", "err", err.Error())
+			p.restoreDraft(userID, draft)
+			_ = p.sendDM(userID, "System error, please try again or type ```reset``` to stop the expense.")
+			return nil, err
+		}
+		if message != "" {
+			p.restoreDraft(userID, draft)
+			_ = p.sendDM(userID, message+" Type ```reset``` to discard your changes.")
+			return nil, errors.New(message)
+		}
+	} else if expense, err = p.createExpense(userID, draft); err != nil {
+		p.API.LogError("failed to create expense", "err", err.Error())
+		p.restoreDraft(userID, draft)
+		_ = p.sendDM(userID, "System error, please try again or type ```reset``` to stop the expense.")
+		return nil, err
+	}
+	if err := p.kvstore.SaveUserDefaults(&UserDefaults{
+		UserID:  userID,
+		Account: draft.Data["iban"],
+		Name:    draft.Data["name"],
+	}); err != nil {
+		p.API.LogError("failed to save user defaults", "err", err.Error())
+	}
+	_ = p.sendDM(userID, "**Expense saved! :tada:**")
+	_ = p.sendDM(userID, "Type ```expense``` to start a new expense")
+	return expense, nil
+}
+
+// restoreDraft puts back the draft submitDraft took, so the user can try again.
+func (p *Plugin) restoreDraft(userID string, draft *Draft) {
+	if err := p.kvstore.SaveDraft(userID, draft); err != nil {
+		p.API.LogError("failed to restore draft", "err", err.Error())
+	}
+}
+
+func (p *Plugin) startExpense(userID string) {
+	_ = p.sendDM(userID, "Let's start the expense, shall we? Type ```back``` to go back to the previous question, ```edit <field>``` to change an answer, or ```reset``` if you change your mind and it will all be over.")
+	userDefaults, err := p.kvstore.GetUserDefaults(userID)
+	if err != nil {
+		p.API.LogError("failed to get user defaults", "err", err.Error())
+	}
+	if userDefaults != nil {
+		draft := &Draft{
+			UserID: userID,
+			State:  DraftStateAskDefaults,
+			Data:   map[string]string{},
+		}
+		err = p.kvstore.SaveDraft(userID, draft)
+		if err != nil {
+			_ = p.sendDM(userID, "System error, please try again")
+		}
+		_ = p.sendDM(userID, fmt.Sprintf("Last time you used account **%s** and name **%s**. Do you want to use them again? (y[es]/n[o])", userDefaults.Account, userDefaults.Name))
+		return
+	}
+	draft := &Draft{
+		UserID: userID,
+		State:  DraftStateAskAccount,
+		Data:   map[string]string{},
+	}
+	err = p.kvstore.SaveDraft(userID, draft)
+	if err != nil {
+		_ = p.sendDM(userID, "System error, please try again")
+	}
+	_ = p.sendDM(userID, "**What is your IBAN?**")
+}
+
 func (p *Plugin) sendDM(userID string, message string) *model.Post {
 	return p.sendPinnedDM(userID, message, false)
 }
 
 func (p *Plugin) sendPinnedDM(userID string, message string, isPinned bool) *model.Post {
+	return p.sendPostDM(userID, &model.Post{
+		Message:  message,
+		IsPinned: isPinned,
+	})
+}
+
+func (p *Plugin) sendPostDM(userID string, post *model.Post) *model.Post {
 	channel, err := p.API.GetDirectChannel(p.botID, userID)
 	if err != nil {
 		p.API.LogError("failed to get direct channel", "err", err.Error())
 		return nil
 	}
-	post, err := p.API.CreatePost(&model.Post{
-		UserId:    p.botID,
-		ChannelId: channel.Id,
-		Message:   message,
-		IsPinned:  isPinned,
-	})
+	post.UserId = p.botID
+	post.ChannelId = channel.Id
+	post, err = p.API.CreatePost(post)
 	if err != nil {
 		p.API.LogError("failed to create post", "err", err.Error())
 		return nil
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/pkg/errors"
+)
+
+const commandTrigger = "expense"
+
+func (p *Plugin) registerCommands() error {
+	autocomplete := model.NewAutocompleteData(commandTrigger, "[command]", "Submit and manage expense claims")
+	autocomplete.AddCommand(model.NewAutocompleteData("new", "", "Start a new expense claim in a DM with the bot"))
+	autocomplete.AddCommand(model.NewAutocompleteData("form", "", "Fill in a new expense claim in a single form"))
+	list := model.NewAutocompleteData("list", "[state] [from YYYY-MM-DD] [to YYYY-MM-DD] [after ID]", "List your expense claims")
+	list.AddTextArgument("Filter by state and date", "[submitted|approved|paid|rejected|cancelled] [from YYYY-MM-DD] [to YYYY-MM-DD] [after ID]", "")
+	autocomplete.AddCommand(list)
+	status := model.NewAutocompleteData("status", "[id]", "Show the status of an expense claim")
+	status.AddTextArgument("ID of the expense claim", "[id]", "")
+	autocomplete.AddCommand(status)
+	edit := model.NewAutocompleteData("edit", "[id]", "Edit a submitted expense claim")
+	edit.AddTextArgument("ID of the expense claim", "[id]", "")
+	autocomplete.AddCommand(edit)
+	resubmit := model.NewAutocompleteData("resubmit", "[id]", "Correct and resubmit a rejected expense claim")
+	resubmit.AddTextArgument("ID of the expense claim", "[id]", "")
+	autocomplete.AddCommand(resubmit)
+	export := model.NewAutocompleteData("export", "[state] [from YYYY-MM-DD] [to YYYY-MM-DD] [user USERNAME] [category NAME] [csv|xlsx]", "Export expense claims as a spreadsheet")
+	export.AddTextArgument("Filter and format", "[submitted|approved|paid|rejected|cancelled] [from YYYY-MM-DD] [to YYYY-MM-DD] [user USERNAME] [category NAME] [csv|xlsx]", "")
+	autocomplete.AddCommand(export)
+	autocomplete.AddCommand(model.NewAutocompleteData("batch", "", "Create a SEPA payment batch of the approved expense claims"))
+	autocomplete.AddCommand(model.NewAutocompleteData("cancel", "", "Discard the expense claim in progress"))
+	autocomplete.AddCommand(model.NewAutocompleteData("reset", "", "Discard the expense claim in progress, like typing reset in the DM"))
+	forget := model.NewAutocompleteData("forget", "[confirm]", "Forget your saved bank account and name")
+	forget.AddStaticListArgument("Confirm", false, []model.AutocompleteListItem{{Item: "confirm", HelpText: "Delete your saved bank account and name"}})
+	autocomplete.AddCommand(forget)
+
+	if err := p.client.SlashCommand.Register(&model.Command{
+		Trigger:          commandTrigger,
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: new, form, list, status, edit, resubmit, export, batch, cancel, reset, forget",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: autocomplete,
+	}); err != nil {
+		return errors.Wrap(err, "failed to register command")
+	}
+	return nil
+}
+
+// ExecuteCommand handles the /expense slash command.
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) == 0 || fields[0] != "/"+commandTrigger {
+		return ephemeralResponse(fmt.Sprintf("Unknown command: %s", args.Command)), nil
+	}
+	subcommand := "new"
+	var params []string
+	if len(fields) > 1 {
+		subcommand = normalizeCmd(fields[1])
+		params = fields[2:]
+	}
+
+	switch subcommand {
+	case "new":
+		return p.executeNew(args), nil
+	case "form":
+		return p.executeForm(args), nil
+	case "list":
+		return p.executeList(args, params), nil
+	case "status":
+		return p.executeStatus(args, params), nil
+	case "edit":
+		return p.executeEdit(args, params), nil
+	case "resubmit":
+		return p.executeResubmit(args, params), nil
+	case "export":
+		return p.executeExport(args, params), nil
+	case "batch":
+		return p.executeBatch(args), nil
+	case "cancel":
+		return p.executeCancel(args), nil
+	case "reset":
+		return p.executeReset(args), nil
+	case "forget":
+		return p.executeForget(args, params), nil
+	case "help":
+		return ephemeralResponse(commandHelp), nil
+	}
+	return ephemeralResponse(fmt.Sprintf("Unknown subcommand **%s**.\n\n%s", subcommand, commandHelp)), nil
+}
+
+const commandHelp = "* `/expense new` - Start a new expense claim\n" +
+	"* `/expense form` - Fill in a new expense claim in a single form\n" +
+	"* `/expense list [state] [from YYYY-MM-DD] [to YYYY-MM-DD] [after ID]` - List your expense claims\n" +
+	"* `/expense status <id>` - Show the status of an expense claim\n" +
+	"* `/expense edit <id>` - Edit a submitted expense claim before it is handled\n" +
+	"* `/expense resubmit <id>` - Correct and resubmit a rejected expense claim\n" +
+	"* `/expense export [state] [from YYYY-MM-DD] [to YYYY-MM-DD] [user USERNAME] [category NAME] [csv|xlsx]` - Export expense claims as a spreadsheet\n" +
+	"* `/expense batch` - Create a SEPA payment batch of the approved expense claims, for payers\n" +
+	"* `/expense cancel` - Discard the expense claim in progress\n" +
+	"* `/expense reset` - Discard the expense claim in progress\n" +
+	"* `/expense forget confirm` - Forget your saved bank account and name"
+
+func (p *Plugin) executeNew(args *model.CommandArgs) *model.CommandResponse {
+	draft, err := p.getActiveDraft(args.UserId)
+	if err != nil {
+		p.API.LogError("failed to get draft", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	if draft != nil {
+		return ephemeralResponse("You already have an expense claim in progress. Continue in your DM with ExpenseBot, or use `/expense cancel` to discard it.")
+	}
+	p.startExpense(args.UserId)
+	return ephemeralResponse("Let's go! I've sent you a direct message to continue your expense claim.")
+}
+
+func (p *Plugin) executeForm(args *model.CommandArgs) *model.CommandResponse {
+	draft, err := p.getActiveDraft(args.UserId)
+	if err != nil {
+		p.API.LogError("failed to get draft", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	if draft != nil {
+		return ephemeralResponse("You already have an expense claim in progress. Continue in your DM with ExpenseBot, or use `/expense cancel` to discard it.")
+	}
+	if err = p.openExpenseDialog(args.TriggerId, args.UserId); err != nil {
+		p.API.LogError("failed to open expense dialog", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	return &model.CommandResponse{}
+}
+
+func (p *Plugin) executeList(args *model.CommandArgs, params []string) *model.CommandResponse {
+	return ephemeralResponse(p.listExpenses(args.UserId, params))
+}
+
+func (p *Plugin) executeStatus(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 {
+		return ephemeralResponse("Usage: `/expense status <id>`")
+	}
+	expense, err := p.kvstore.GetExpense(params[0])
+	if err != nil {
+		p.API.LogError("failed to get expense", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	if expense == nil || expense.UserID != args.UserId {
+		return ephemeralResponse(fmt.Sprintf("Expense claim **%s** not found.", params[0]))
+	}
+	message, err := p.formatExpense(expense)
+	if err != nil {
+		p.API.LogError("failed to format expense", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	return ephemeralResponse(message)
+}
+
+func (p *Plugin) executeEdit(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 {
+		return ephemeralResponse("Usage: `/expense edit <id>`")
+	}
+	message, err := p.editExpense(args.UserId, params[0])
+	if err != nil {
+		p.API.LogError("failed to edit expense", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	if message == "" {
+		message = "I've sent you a direct message to edit your expense claim."
+	}
+	return ephemeralResponse(message)
+}
+
+func (p *Plugin) executeResubmit(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 {
+		return ephemeralResponse("Usage: `/expense resubmit <id>`")
+	}
+	message, err := p.resubmitExpense(args.UserId, params[0])
+	if err != nil {
+		p.API.LogError("failed to resubmit expense", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	if message == "" {
+		message = "I've sent you a direct message to correct your expense claim."
+	}
+	return ephemeralResponse(message)
+}
+
+func (p *Plugin) executeExport(args *model.CommandArgs, params []string) *model.CommandResponse {
+	message, err := p.exportExpenses(args.UserId, params)
+	if err != nil {
+		p.API.LogError("failed to export expenses", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	return ephemeralResponse(message)
+}
+
+func (p *Plugin) executeBatch(args *model.CommandArgs) *model.CommandResponse {
+	message, err := p.createPaymentBatch(args.UserId)
+	if err != nil {
+		p.API.LogError("failed to create payment batch", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	return ephemeralResponse(message)
+}
+
+func (p *Plugin) executeCancel(args *model.CommandArgs) *model.CommandResponse {
+	draft, err := p.getActiveDraft(args.UserId)
+	if err != nil {
+		p.API.LogError("failed to get draft", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	if draft == nil {
+		return ephemeralResponse("You have no expense claim in progress.")
+	}
+	if err = p.kvstore.DeleteDraft(args.UserId); err != nil {
+		p.API.LogError("failed to delete draft", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	return ephemeralResponse("Your expense claim in progress has been discarded.")
+}
+
+// executeReset discards the draft like typing reset in the DM does. The saved bank account is kept,
+// forgetting it is left to executeForget.
+func (p *Plugin) executeReset(args *model.CommandArgs) *model.CommandResponse {
+	if err := p.kvstore.DeleteDraft(args.UserId); err != nil {
+		p.API.LogError("failed to delete draft", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	return ephemeralResponse("All clear! Use `/expense new` to start a new expense.")
+}
+
+// executeForget deletes the saved bank account and name of the user, once they confirm.
+func (p *Plugin) executeForget(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) != 1 || normalizeCmd(params[0]) != "confirm" {
+		return ephemeralResponse("This deletes your saved bank account and name, you will have to fill them in on your next expense claim. Use `/expense forget confirm` to continue.")
+	}
+	if err := p.kvstore.DeleteUserDefaults(args.UserId); err != nil {
+		p.API.LogError("failed to delete user defaults", "err", err.Error())
+		return ephemeralResponse("System error, please try again.")
+	}
+	return ephemeralResponse("Your saved bank account and name are gone.")
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
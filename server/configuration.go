@@ -2,6 +2,10 @@ package main
 
 import (
 	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -19,15 +23,153 @@ import (
 // copy appropriate for your types.
 type configuration struct {
 	ChannelID string
+
+	// FinanceChannelID is the channel approved claims are posted to for payment. When empty, claims
+	// are paid from the post in ChannelID.
+	FinanceChannelID string
+
+	// ReimbursementCurrency is the ISO 4217 currency expenses are paid out in.
+	ReimbursementCurrency string
+
+	// ExchangeRates is a table of currencies and their value in the reimbursement currency, one per
+	// line, e.g. "USD 0.92". ExchangeRatesDate is the date the rates were taken.
+	ExchangeRates     string
+	ExchangeRatesDate string
+
+	// ExchangeRateProviderURL is an HTTP service to fetch rates from, used instead of the
+	// ExchangeRates table when set.
+	ExchangeRateProviderURL string
+
+	// Approvers and Payers are lists of usernames and group names allowed to approve and to pay
+	// expense claims.
+	Approvers string
+	Payers    string
+
+	// DebtorName, DebtorIBAN and DebtorBIC are the account SEPA payment batches are paid from. The
+	// BIC is optional.
+	DebtorName string
+	DebtorIBAN string
+	DebtorBIC  string
+
+	// Categories is a JSON list of expense categories and their rules, see Category.
+	Categories string
+
+	// ApprovedNotification, PaidNotification and RejectedNotification are the templates of the
+	// messages sent to the submitter when their claim changes state, see notificationData.
+	ApprovedNotification string
+	PaidNotification     string
+	RejectedNotification string
+
+	// UnpinClosedClaims unpins the DM post of a claim once it reaches a final state.
+	UnpinClosedClaims bool
+
+	// MonthlyReportDay is the day of the month, 1 to 28, the report of the previous month is posted
+	// to the finance channel on. Empty disables the report.
+	MonthlyReportDay string
+
+	// ReminderDays is how many days a claim may stay submitted before the approvers are reminded,
+	// EscalationDays before it is escalated to the EscalationContacts, a list of usernames and
+	// group names. Empty disables them. RemindApproversByDM also sends the reminder to each approver.
+	ReminderDays        string
+	EscalationDays      string
+	EscalationContacts  string
+	RemindApproversByDM bool
+
+	// DraftExpiryHours is how long a draft is kept without activity, DraftNudgeHours after how
+	// long the user is reminded of it. Empty disables them.
+	DraftExpiryHours string
+	DraftNudgeHours  string
+
+	// The fields below are computed by prepare.
+	categories     []*Category
+	notifications  map[string]*template.Template
+	reportDay      int
+	reminderDays   int
+	escalationDays int
+	draftExpiry    time.Duration
+	draftNudge     time.Duration
+}
+
+// prepare computes the values derived from the public configuration fields.
+func (c *configuration) prepare() error {
+	categories, err := parseCategories(c.Categories, c.reimbursementCurrency())
+	if err != nil {
+		return err
+	}
+	c.categories = categories
+	notifications, err := parseNotifications(map[string]string{
+		ExpenseStateApproved: c.ApprovedNotification,
+		ExpenseStatePaid:     c.PaidNotification,
+		ExpenseStateRejected: c.RejectedNotification,
+	})
+	if err != nil {
+		return err
+	}
+	c.notifications = notifications
+	if day := strings.TrimSpace(c.MonthlyReportDay); day != "" {
+		if c.reportDay, err = strconv.Atoi(day); err != nil || c.reportDay < 1 || c.reportDay > 28 {
+			return errors.Errorf("invalid monthly report day %s, use 1 to 28", day)
+		}
+	}
+	if c.reminderDays, err = parsePositiveInt(c.ReminderDays); err != nil {
+		return errors.Wrap(err, "invalid reminder days")
+	}
+	if c.escalationDays, err = parsePositiveInt(c.EscalationDays); err != nil {
+		return errors.Wrap(err, "invalid escalation days")
+	}
+	if c.reminderDays > 0 && c.escalationDays > 0 && c.escalationDays <= c.reminderDays {
+		return errors.Errorf("escalation days %d must be more than the reminder days %d", c.escalationDays, c.reminderDays)
+	}
+	hours, err := parsePositiveInt(c.DraftExpiryHours)
+	if err != nil {
+		return errors.Wrap(err, "invalid draft expiry hours")
+	}
+	c.draftExpiry = time.Duration(hours) * time.Hour
+	if hours, err = parsePositiveInt(c.DraftNudgeHours); err != nil {
+		return errors.Wrap(err, "invalid draft nudge hours")
+	}
+	c.draftNudge = time.Duration(hours) * time.Hour
+	return nil
+}
+
+// parsePositiveInt parses a positive number, empty being zero.
+func parsePositiveInt(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, errors.Errorf("%s is not a positive number", value)
+	}
+	return n, nil
 }
 
-// Clone shallow copies the configuration. Your implementation may require a deep copy if
-// your configuration has reference types.
+// Clone shallow copies the configuration. The categories and notifications are shared, they are
+// never modified after prepare.
 func (c *configuration) Clone() *configuration {
 	var clone = *c
 	return &clone
 }
 
+// reimbursementCurrency returns the configured reimbursement currency, or the default currency when
+// none or an unsupported one is configured.
+func (c *configuration) reimbursementCurrency() string {
+	currency := strings.ToUpper(strings.TrimSpace(c.ReimbursementCurrency))
+	if _, ok := currencyDecimals[currency]; !ok {
+		return defaultCurrency
+	}
+	return currency
+}
+
+// rateProvider returns the provider of the exchange rates used to convert foreign currencies.
+func (c *configuration) rateProvider() RateProvider {
+	if c.ExchangeRateProviderURL != "" {
+		return newHTTPRateProvider(c.ExchangeRateProviderURL)
+	}
+	return tableRateProvider{table: c.ExchangeRates, date: c.ExchangeRatesDate}
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
@@ -77,6 +219,9 @@ func (p *Plugin) OnConfigurationChange() error {
 	if err := p.API.LoadPluginConfiguration(configuration); err != nil {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
+	if err := configuration.prepare(); err != nil {
+		return errors.Wrap(err, "invalid plugin configuration")
+	}
 
 	p.setConfiguration(configuration)
 
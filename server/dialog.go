@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/almerlucke/go-iban/iban"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const dialogCallbackExpense = "expense"
+
+func (p *Plugin) openExpenseDialog(triggerID string, userID string) error {
+	userDefaults, err := p.kvstore.GetUserDefaults(userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get user defaults")
+	}
+	if userDefaults == nil {
+		userDefaults = &UserDefaults{}
+	}
+
+	config := p.getConfiguration()
+	dialog := model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       pluginURL + "/api/dialogs/expense",
+		Dialog: model.Dialog{
+			CallbackId:       dialogCallbackExpense,
+			Title:            "New expense claim",
+			IntroductionText: "Fill in the details of your expense. After submitting, I will ask you for the receipt in a direct message.",
+			SubmitLabel:      "Next",
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "IBAN",
+					Name:        "iban",
+					Type:        "text",
+					Default:     userDefaults.Account,
+					Placeholder: "NL91ABNA0417164300",
+				},
+				{
+					DisplayName: "Account holder",
+					Name:        "name",
+					Type:        "text",
+					Default:     userDefaults.Name,
+					HelpText:    "In what name is the account held?",
+				},
+				{
+					DisplayName: "Amount",
+					Name:        "amount",
+					Type:        "text",
+					Placeholder: "100.00",
+					HelpText:    "If you combine multiple receipts, fill in the total amount, or use /expense new to itemize them. Add the currency if you didn't pay in " + config.reimbursementCurrency() + ", e.g. 12.50 USD.",
+				},
+				{
+					DisplayName: "Category",
+					Name:        "category",
+					Type:        "select",
+					Options:     categoryOptions(config.categories),
+				},
+				{
+					DisplayName: "Description",
+					Name:        "description",
+					Type:        "textarea",
+					HelpText:    "In a few words, describe the expense.",
+					MaxLength:   500,
+				},
+			},
+		},
+	}
+	if len(config.categories) == 0 {
+		dialog.Dialog.Elements = slices.DeleteFunc(dialog.Dialog.Elements, func(element model.DialogElement) bool {
+			return element.Name == "category"
+		})
+	}
+	if appErr := p.API.OpenInteractiveDialog(dialog); appErr != nil {
+		return errors.Wrap(appErr, "failed to open dialog")
+	}
+	return nil
+}
+
+// validateExpenseSubmission checks the submitted dialog fields and returns the draft, or the errors
+// per field when the submission is invalid.
+func validateExpenseSubmission(submission map[string]any, config *configuration, convert func(Money) (Money, *Conversion, error)) (*Draft, map[string]string) {
+	currency := config.reimbursementCurrency()
+	draft := &Draft{Data: map[string]string{}}
+	fieldErrors := map[string]string{}
+
+	value := func(name string) string {
+		s, _ := submission[name].(string)
+		return strings.TrimSpace(s)
+	}
+
+	account, err := iban.NewIBAN(value("iban"))
+	if err != nil {
+		fieldErrors["iban"] = "Invalid IBAN."
+	} else {
+		draft.Data["iban"] = account.PrintCode
+	}
+	for _, name := range []string{"name", "description"} {
+		if draft.Data[name] = value(name); draft.Data[name] == "" {
+			fieldErrors[name] = "This field is required."
+		}
+	}
+	if amount, err := parseMoney(value("amount"), currency); err != nil {
+		fieldErrors["amount"] = "Invalid amount: " + err.Error() + "."
+	} else if converted, conversion, err := convert(amount); err != nil {
+		fieldErrors["amount"] = fmt.Sprintf("Can't convert %s, please enter the amount in %s.", amount.Currency, currency)
+	} else {
+		draft.Items = []LineItem{{
+			Description: draft.Data["description"],
+			Amount:      converted,
+			Conversion:  conversion,
+		}}
+	}
+
+	if len(config.categories) > 0 {
+		category := config.getCategory(value("category"))
+		if category == nil {
+			fieldErrors["category"] = "Pick a category."
+		} else {
+			draft.Data["category"] = category.Name
+			if len(draft.Items) > 0 {
+				draft.Items[0].Category = category.Name
+				if problem := category.checkAmount(draft.Items[0].Amount); problem != "" {
+					fieldErrors["amount"] = strings.ReplaceAll(problem, "**", "")
+				}
+			}
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors
+	}
+	return draft, nil
+}
+
+func (p *Plugin) submitExpenseDialog(request *model.SubmitDialogRequest) (*model.SubmitDialogResponse, error) {
+	// The user may have started a claim in the DM since opening the dialog
+	active, err := p.getActiveDraft(request.UserId)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get draft")
+	}
+	if active != nil {
+		return &model.SubmitDialogResponse{Error: "You already have an expense claim in progress. Continue in your DM with ExpenseBot, or use /expense cancel to discard it."}, nil
+	}
+
+	draft, fieldErrors := validateExpenseSubmission(request.Submission, p.getConfiguration(), p.convertAmount)
+	if fieldErrors != nil {
+		return &model.SubmitDialogResponse{Errors: fieldErrors}, nil
+	}
+	draft.UserID = request.UserId
+	draft.State = DraftStateAskFile
+	if err := p.kvstore.SaveDraft(request.UserId, draft); err != nil {
+		return nil, errors.Wrap(err, "failed to save draft")
+	}
+	_ = p.sendDM(request.UserId, fmt.Sprintf("Thanks! Got your claim of **%s** for **%s**. If you change your mind, type ```reset```.", draft.Items[0].Amount, draft.Data["description"]))
+	_ = p.sendDM(request.UserId, askFileMessage)
+	return &model.SubmitDialogResponse{}, nil
+}
+
+func categoryOptions(categories []*Category) []*model.PostActionOptions {
+	options := make([]*model.PostActionOptions, 0, len(categories))
+	for _, category := range categories {
+		options = append(options, &model.PostActionOptions{Text: category.Name, Value: category.Name})
+	}
+	return options
+}
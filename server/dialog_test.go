@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+func TestValidateExpenseSubmission(t *testing.T) {
+	withCategories := &configuration{categories: []*Category{{Name: "Meals", maxAmount: &Money{Value: 5000, Currency: "EUR"}}, {Name: "Travel"}}}
+	convert := func(amount Money) (Money, *Conversion, error) {
+		if amount.Currency != "EUR" {
+			return Money{}, nil, errors.New("no rate")
+		}
+		return amount, nil, nil
+	}
+	valid := map[string]any{"iban": "NL91 ABNA 0417 1643 00", "name": "Sam", "amount": "12,50", "description": "Lunch", "category": "meals"}
+	with := func(name string, value any) map[string]any {
+		submission := map[string]any{}
+		for key, v := range valid {
+			submission[key] = v
+		}
+		submission[name] = value
+		return submission
+	}
+	for name, tc := range map[string]struct {
+		submission     map[string]any
+		config         *configuration
+		expectedFields []string
+	}{
+		"valid":                    {submission: valid, config: withCategories},
+		"valid without categories": {submission: with("category", nil), config: &configuration{}},
+		"invalid iban":             {submission: with("iban", "NL00 0000"), config: withCategories, expectedFields: []string{"iban"}},
+		"missing name":             {submission: with("name", " "), config: withCategories, expectedFields: []string{"name"}},
+		"missing description":      {submission: with("description", nil), config: withCategories, expectedFields: []string{"description"}},
+		"bad amount":               {submission: with("amount", "lots"), config: withCategories, expectedFields: []string{"amount"}},
+		"failed conversion":        {submission: with("amount", "10 USD"), config: withCategories, expectedFields: []string{"amount"}},
+		"unknown category":         {submission: with("category", "Snacks"), config: withCategories, expectedFields: []string{"category"}},
+		"over category maximum":    {submission: with("amount", "50,01"), config: withCategories, expectedFields: []string{"amount"}},
+		"several errors":           {submission: map[string]any{"amount": "12,50"}, config: &configuration{}, expectedFields: []string{"iban", "name", "description"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			draft, fieldErrors := validateExpenseSubmission(tc.submission, tc.config, convert)
+			if len(fieldErrors) != len(tc.expectedFields) {
+				t.Logf("expected errors for %v, got %v", tc.expectedFields, fieldErrors)
+				t.FailNow()
+			}
+			for _, field := range tc.expectedFields {
+				if fieldErrors[field] == "" {
+					t.Logf("expected an error for %s, got %v", field, fieldErrors)
+					t.Fail()
+				}
+			}
+			if len(tc.expectedFields) > 0 {
+				if draft != nil {
+					t.Logf("expected no draft, got %+v", draft)
+					t.Fail()
+				}
+				return
+			}
+			if draft == nil || draft.Data["iban"] != "NL91 ABNA 0417 1643 00" || len(draft.Items) != 1 || draft.Items[0].Amount.Value != 1250 {
+				t.Logf("unexpected draft %+v", draft)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestSubmitExpenseDialogKeepsDraft(t *testing.T) {
+	kv, api, data := newTestStore()
+	p := &Plugin{kvstore: kv}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+	started := &Draft{UserID: "user", State: DraftStateAskName, Data: map[string]string{"iban": "NL91ABNA0417164300"}}
+	if err := kv.SaveDraft("user", started); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	stored := string(data["draft:user"])
+
+	response, err := p.submitExpenseDialog(&model.SubmitDialogRequest{
+		UserId:     "user",
+		Submission: map[string]any{"iban": "NL91ABNA0417164300", "name": "Sam", "amount": "12,50", "description": "Lunch"},
+	})
+	if err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	if response.Error == "" {
+		t.Logf("expected the dialog to tell about the claim in progress, got %+v", response)
+		t.Fail()
+	}
+	if string(data["draft:user"]) != stored {
+		t.Logf("expected the draft in progress to be kept, got %s", data["draft:user"])
+		t.Fail()
+	}
+}
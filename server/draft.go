@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// draftCheckInterval is how often the draft job looks for abandoned drafts.
+	draftCheckInterval = 10 * time.Minute
+
+	// draftExpiryGrace is how much longer than the draft expiry drafts are kept in the KV store,
+	// giving the draft job time to tell the user before they disappear.
+	draftExpiryGrace = time.Hour
+)
+
+// draftIdle returns how long the draft has been left alone at now. Drafts saved before they had
+// timestamps are never idle.
+func draftIdle(draft *Draft, now time.Time) time.Duration {
+	if draft.UpdateAt == 0 {
+		return 0
+	}
+	return now.Sub(time.UnixMilli(draft.UpdateAt))
+}
+
+// draftExpired tells whether the draft was left alone for longer than the draft expiry.
+func (p *Plugin) draftExpired(draft *Draft, now time.Time) bool {
+	expiry := p.getConfiguration().draftExpiry
+	return expiry > 0 && draftIdle(draft, now) > expiry
+}
+
+// getActiveDraft returns the draft of the user, or nil when they have none. An expired draft is
+// discarded, so the next message of the user is not taken for an answer to a question asked long
+// ago.
+func (p *Plugin) getActiveDraft(userID string) (*Draft, error) {
+	draft, err := p.kvstore.GetDraft(userID)
+	if err != nil || draft == nil {
+		return draft, err
+	}
+	if p.draftExpired(draft, time.Now()) {
+		p.discardExpiredDraft(draft)
+		return nil, nil
+	}
+	return draft, nil
+}
+
+// discardExpiredDraft deletes the draft and tells the user why. A draft the user touched since it
+// was read is left alone.
+func (p *Plugin) discardExpiredDraft(draft *Draft) {
+	ok, err := p.kvstore.DeleteDraftIfUnchanged(draft)
+	if err != nil {
+		p.API.LogError("failed to delete draft", "err", err.Error())
+		return
+	}
+	if !ok {
+		return
+	}
+	message := fmt.Sprintf("I discarded your expense claim in progress after %s without an answer.", formatHours(p.getConfiguration().draftExpiry))
+	if draft.ExpenseID != "" {
+		message = fmt.Sprintf("I discarded your changes to expense claim **%s** after %s without an answer, the claim is unchanged.", draft.ExpenseID, formatHours(p.getConfiguration().draftExpiry))
+	}
+	_ = p.sendDM(draft.UserID, message+" Type ```expense``` to start a new expense.")
+}
+
+// runDraftExpiry reminds users of the drafts they left alone, and discards the expired ones.
+func (p *Plugin) runDraftExpiry() {
+	config := p.getConfiguration()
+	if config.draftExpiry == 0 && config.draftNudge == 0 {
+		return
+	}
+	drafts, err := p.kvstore.ListDrafts()
+	if err != nil {
+		p.API.LogError("failed to list drafts", "err", err.Error())
+		return
+	}
+	now := time.Now()
+	for _, draft := range drafts {
+		if p.draftExpired(draft, now) {
+			p.discardExpiredDraft(draft)
+			continue
+		}
+		if config.draftNudge == 0 || draftIdle(draft, now) < config.draftNudge || draft.NudgedAt >= draft.UpdateAt {
+			continue
+		}
+		ok, err := p.kvstore.MarkDraftNudged(draft)
+		if err != nil {
+			p.API.LogError("failed to save draft", "err", err.Error())
+			continue
+		}
+		if !ok {
+			// The user answered in the meantime
+			continue
+		}
+		message := "**Still there?** Your expense claim in progress is waiting for your answer to my last question. Type ```reset``` to discard it."
+		if config.draftExpiry > 0 {
+			message += fmt.Sprintf(" Otherwise I'll discard it in %s.", formatHours(config.draftExpiry-draftIdle(draft, now)))
+		}
+		_ = p.sendDM(draft.UserID, message)
+	}
+}
+
+// formatHours renders the duration in whole hours, at least one.
+func formatHours(d time.Duration) string {
+	hours := int(d.Round(time.Hour).Hours())
+	if hours <= 1 {
+		return "1 hour"
+	}
+	return fmt.Sprintf("%d hours", hours)
+}
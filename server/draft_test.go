@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDraftExpired(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	hoursAgo := func(hours int) int64 {
+		return now.Add(-time.Duration(hours) * time.Hour).UnixMilli()
+	}
+	for name, tc := range map[string]struct {
+		updateAt int64
+		expiry   time.Duration
+		expected bool
+	}{
+		"recent":             {updateAt: hoursAgo(1), expiry: 24 * time.Hour},
+		"expired":            {updateAt: hoursAgo(25), expiry: 24 * time.Hour, expected: true},
+		"expiry disabled":    {updateAt: hoursAgo(1000)},
+		"without timestamps": {expiry: 24 * time.Hour},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := &Plugin{configuration: &configuration{draftExpiry: tc.expiry}}
+			if actual := p.draftExpired(&Draft{UpdateAt: tc.updateAt}, now); actual != tc.expected {
+				t.Logf("expected %v, got %v", tc.expected, actual)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestDraftChangedSinceRead(t *testing.T) {
+	for name, tc := range map[string]struct {
+		touched  bool
+		expected bool
+	}{
+		"unchanged": {expected: true},
+		"touched":   {touched: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			kv, _, data := newTestStore()
+			if err := kv.SaveDraft("user", &Draft{UserID: "user", State: DraftStateAskItems}); err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			read, err := kv.GetDraft("user")
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if tc.touched {
+				// The user answers while the draft job looks at the draft
+				answered := *read
+				answered.Data = map[string]string{"amount": "12.50"}
+				if err = kv.SaveDraft("user", &answered); err != nil {
+					t.Logf("expected no error, got %v", err)
+					t.FailNow()
+				}
+			}
+			stored := string(data["draft:user"])
+
+			nudged, err := kv.MarkDraftNudged(read)
+			if err != nil || nudged != tc.expected {
+				t.Logf("expected nudged %v, got %v, %v", tc.expected, nudged, err)
+				t.Fail()
+			}
+			if !tc.touched && read.NudgedAt == 0 {
+				t.Logf("expected the nudge to be recorded")
+				t.Fail()
+			}
+			if tc.touched && string(data["draft:user"]) != stored {
+				t.Logf("expected the answered draft to be kept, got %s", data["draft:user"])
+				t.Fail()
+			}
+
+			deleted, err := kv.DeleteDraftIfUnchanged(read)
+			if err != nil || deleted != tc.expected {
+				t.Logf("expected deleted %v, got %v, %v", tc.expected, deleted, err)
+				t.Fail()
+			}
+			if _, ok := data["draft:user"]; ok == tc.expected {
+				t.Logf("expected draft to be deleted %v", tc.expected)
+				t.Fail()
+			}
+		})
+	}
+}
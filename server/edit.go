@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Edit records the claim as it was before the submitter edited it.
+type Edit struct {
+	CreateAt    int64      `json:"create_at"`
+	Account     string     `json:"bank_account"`
+	Name        string     `json:"name"`
+	Amount      Money      `json:"amount"`
+	Description string     `json:"description"`
+	Category    string     `json:"category,omitempty"`
+	Items       []LineItem `json:"items,omitempty"`
+	FileIDs     []string   `json:"file_ids"`
+}
+
+// editExpense starts a draft to edit a submitted expense of the user. It returns a message for the
+// user.
+func (p *Plugin) editExpense(userID, expenseID string) (string, error) {
+	expense, err := p.kvstore.GetExpense(expenseID)
+	if err != nil {
+		return "", err
+	}
+	if expense == nil || expense.UserID != userID {
+		return "This expense claim no longer exists.", nil
+	}
+	if expense.State != ExpenseStateSubmitted {
+		return fmt.Sprintf("This expense claim is **%s**, only submitted claims can be edited.", expense.State), nil
+	}
+	draft, err := p.getActiveDraft(userID)
+	if err != nil {
+		return "", err
+	}
+	if draft != nil {
+		return "You already have an expense claim in progress. Finish it, or type ```reset``` to discard it, and try again.", nil
+	}
+
+	draft = draftFromExpense(expense)
+	draft.ExpenseID = expense.ID
+	draft.State = DraftStateAskItems
+	if err = p.kvstore.SaveDraft(userID, draft); err != nil {
+		return "", err
+	}
+	items, err := p.formatItems(draft.Items, p.getConfiguration().reimbursementCurrency())
+	if err != nil {
+		return "", err
+	}
+	_ = p.sendDM(userID, "Let's edit your claim. It stays submitted as it is until you're done, type ```reset``` to discard your changes.")
+	_ = p.sendDM(userID, items+"\nAdd an item as ```amount; description; category```, type ```remove <number>``` to remove one, or ```done``` when the items are correct.")
+	return "", nil
+}
+
+// updateExpenseFromDraft replaces the data of the expense the draft edits, keeping the previous
+// version in its edits. The approval post is updated, or superseded by a new post when the claim
+// moved to another channel. It returns the updated expense, or a message for the user when the
+// expense can no longer be edited.
+func (p *Plugin) updateExpenseFromDraft(draft *Draft) (*Expense, string, error) {
+	expense, err := p.kvstore.GetExpense(draft.ExpenseID)
+	if err != nil {
+		return nil, "", err
+	}
+	if expense == nil {
+		return nil, "The expense claim you are editing no longer exists.", nil
+	}
+	if expense.State != ExpenseStateSubmitted {
+		return nil, fmt.Sprintf("Your expense claim is already **%s**, so it can no longer be edited.", expense.State), nil
+	}
+	edited, err := p.expenseFromDraft(draft)
+	if err != nil {
+		return nil, "", err
+	}
+
+	expense.Edits = append(expense.Edits, Edit{
+		CreateAt:    model.GetMillis(),
+		Account:     expense.Account,
+		Name:        expense.Name,
+		Amount:      expense.Amount,
+		Description: expense.Description,
+		Category:    expense.Category,
+		Items:       expense.Items,
+		FileIDs:     expense.FileIDs,
+	})
+	applyEdit(expense, edited)
+
+	oldPostID := expense.ChannelPostID
+	moved, err := p.approvalChannelChanged(expense)
+	if err != nil {
+		return nil, "", err
+	}
+	if moved {
+		if err = p.sendChannelMessage(expense); err != nil {
+			return nil, "", err
+		}
+	}
+	if err = p.kvstore.SaveExpense(expense); err != nil {
+		if moved {
+			_ = p.API.DeletePost(expense.ChannelPostID)
+		}
+		if errors.Is(err, ErrExpenseConflict) {
+			return nil, p.conflictMessage(expense.ID), nil
+		}
+		return nil, "", err
+	}
+
+	if moved {
+		if err = p.supersedePost(oldPostID); err != nil {
+			p.API.LogError("failed to supersede channel post", "err", err.Error())
+		}
+	}
+	if err = p.updateUser(expense); err != nil {
+		p.API.LogError("failed to update user post", "err", err.Error())
+	}
+	if err = p.updateChannel(expense, ""); err != nil {
+		p.API.LogError("failed to update channel post", "err", err.Error())
+	}
+	return expense, "", nil
+}
+
+// approvalChannelChanged tells whether the category of the expense is handled in another channel
+// than the one its approval post is in.
+func (p *Plugin) approvalChannelChanged(expense *Expense) (bool, error) {
+	if expense.ChannelPostID == "" {
+		return false, nil
+	}
+	post, appErr := p.API.GetPost(expense.ChannelPostID)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to get post")
+	}
+	return post.ChannelId != p.approvalChannelID(expense), nil
+}
+
+// supersedePost replaces an approval post of an edited expense that moved to another channel.
+func (p *Plugin) supersedePost(postID string) error {
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get post")
+	}
+	post.Message = "~~This expense claim was edited and moved to another channel.~~"
+	post.FileIds = nil
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{})
+	if _, appErr = p.API.UpdatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to update post")
+	}
+	return nil
+}
+
+// applyEdit changes the fields of the expense the user can edit to those of edited.
+func applyEdit(expense, edited *Expense) {
+	expense.Account = edited.Account
+	expense.Name = edited.Name
+	expense.Amount = edited.Amount
+	expense.Description = edited.Description
+	expense.Category = edited.Category
+	expense.Items = edited.Items
+	expense.FileIDs = edited.FileIDs
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+// mockPosts backs the post methods of the API with the map.
+func mockPosts(api *plugintest.API, posts map[string]*model.Post) {
+	api.On("GetPost", mock.AnythingOfType("string")).Return(func(postID string) (*model.Post, *model.AppError) {
+		if post, ok := posts[postID]; ok {
+			return post.Clone(), nil
+		}
+		return nil, model.NewAppError("GetPost", "not_found", nil, "", 404)
+	}).Maybe()
+	api.On("CreatePost", mock.Anything).Return(func(post *model.Post) (*model.Post, *model.AppError) {
+		post = post.Clone()
+		post.Id = model.NewId()
+		posts[post.Id] = post
+		return post.Clone(), nil
+	}).Maybe()
+	api.On("UpdatePost", mock.Anything).Return(func(post *model.Post) (*model.Post, *model.AppError) {
+		posts[post.Id] = post.Clone()
+		return post.Clone(), nil
+	}).Maybe()
+	api.On("DeletePost", mock.AnythingOfType("string")).Return(func(postID string) *model.AppError {
+		delete(posts, postID)
+		return nil
+	}).Maybe()
+}
+
+func TestUpdateExpenseFromDraft(t *testing.T) {
+	for name, tc := range map[string]struct {
+		state           string
+		deleted         bool
+		category        string
+		expectedMessage bool
+		expectedChannel string
+	}{
+		"same channel":        {state: ExpenseStateSubmitted, category: "meals", expectedChannel: "expenses"},
+		"moved channel":       {state: ExpenseStateSubmitted, category: "travel", expectedChannel: "travel"},
+		"already approved":    {state: ExpenseStateApproved, category: "travel", expectedMessage: true, expectedChannel: "expenses"},
+		"no longer available": {state: ExpenseStateSubmitted, deleted: true, expectedMessage: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			data := map[string][]byte{}
+			mockKV(api, data)
+			posts := map[string]*model.Post{
+				"dm":       {Id: "dm", ChannelId: "direct", Message: "claim"},
+				"approval": {Id: "approval", ChannelId: "expenses", Message: "claim"},
+			}
+			mockPosts(api, posts)
+			api.On("GetChannel", mock.AnythingOfType("string")).Return(func(channelID string) (*model.Channel, *model.AppError) {
+				return &model.Channel{Id: channelID}, nil
+			}).Maybe()
+			api.On("GetUser", "submitter").Return(&model.User{Id: "submitter", FirstName: "Sam"}, nil).Maybe()
+
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.kvstore = Store{api: api}
+			p.setConfiguration(&configuration{
+				ChannelID:  "expenses",
+				categories: []*Category{{Name: "Meals"}, {Name: "Travel", ChannelID: "travel"}},
+			})
+
+			item := LineItem{Description: "Lunch", Amount: Money{Value: 1250, Currency: "EUR"}}
+			expense := &Expense{
+				ID:            "expense",
+				UserID:        "submitter",
+				State:         tc.state,
+				PostID:        "dm",
+				ChannelPostID: "approval",
+				Account:       "NL91ABNA0417164300",
+				Name:          "Sam",
+				Description:   "Lunch",
+				Category:      "Meals",
+				Amount:        item.Amount,
+				Items:         []LineItem{item},
+			}
+			if !tc.deleted {
+				if err := p.kvstore.SaveExpense(expense); err != nil {
+					t.Logf("expected no error, got %v", err)
+					t.FailNow()
+				}
+			}
+
+			edited := LineItem{Description: "Train", Amount: Money{Value: 4000, Currency: "EUR"}}
+			draft := &Draft{
+				UserID:    "submitter",
+				ExpenseID: "expense",
+				Data: map[string]string{
+					"iban":        expense.Account,
+					"name":        expense.Name,
+					"description": "Train to the customer",
+					"category":    tc.category,
+				},
+				Items: []LineItem{edited},
+			}
+			_, message, err := p.updateExpenseFromDraft(draft)
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if (message != "") != tc.expectedMessage {
+				t.Logf("expected message %v, got %q", tc.expectedMessage, message)
+				t.Fail()
+			}
+
+			saved, err := p.kvstore.GetExpense("expense")
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if tc.deleted {
+				if saved != nil {
+					t.Logf("expected no expense, got %+v", saved)
+					t.Fail()
+				}
+				return
+			}
+			if tc.expectedMessage {
+				if saved.Description != "Lunch" || len(saved.Edits) != 0 {
+					t.Logf("expected expense to be unchanged, got %+v", saved)
+					t.Fail()
+				}
+				return
+			}
+
+			if saved.Description != "Train to the customer" || saved.Amount.Value != 4000 || saved.Category != tc.category {
+				t.Logf("expected edited expense, got %+v", saved)
+				t.Fail()
+			}
+			if len(saved.Edits) != 1 || saved.Edits[0].Description != "Lunch" || saved.Edits[0].Amount.Value != 1250 {
+				t.Logf("expected previous version in edits, got %+v", saved.Edits)
+				t.Fail()
+			}
+			approval := posts[saved.ChannelPostID]
+			if approval == nil || approval.ChannelId != tc.expectedChannel || !strings.Contains(approval.Message, "Train to the customer") {
+				t.Logf("expected approval post of the edit in %s, got %+v", tc.expectedChannel, approval)
+				t.Fail()
+			}
+			moved := saved.ChannelPostID != "approval"
+			if moved != (tc.expectedChannel != "expenses") {
+				t.Logf("expected post to move to %s, got post %s", tc.expectedChannel, saved.ChannelPostID)
+				t.Fail()
+			}
+			if moved && !strings.Contains(posts["approval"].Message, "moved to another channel") {
+				t.Logf("expected old approval post to be superseded, got %q", posts["approval"].Message)
+				t.Fail()
+			}
+			if !strings.Contains(posts["dm"].Message, "Train to the customer") {
+				t.Logf("expected DM to show the edit, got %q", posts["dm"].Message)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestSubmitDraftOnce(t *testing.T) {
+	api := &plugintest.API{}
+	data := map[string][]byte{}
+	mockKV(api, data)
+	mockPosts(api, map[string]*model.Post{
+		"dm":       {Id: "dm", ChannelId: "direct", Message: "claim"},
+		"approval": {Id: "approval", ChannelId: "expenses", Message: "claim"},
+	})
+	api.On("GetChannel", mock.AnythingOfType("string")).Return(func(channelID string) (*model.Channel, *model.AppError) {
+		return &model.Channel{Id: channelID}, nil
+	}).Maybe()
+	api.On("GetDirectChannel", mock.AnythingOfType("string"), "submitter").Return(&model.Channel{Id: "direct"}, nil).Maybe()
+	api.On("GetUser", "submitter").Return(&model.User{Id: "submitter", FirstName: "Sam"}, nil).Maybe()
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.kvstore = Store{api: api, draftExpiry: func() time.Duration { return 0 }}
+	p.setConfiguration(&configuration{ChannelID: "expenses", categories: []*Category{{Name: "Meals"}}})
+
+	item := LineItem{Description: "Lunch", Amount: Money{Value: 1250, Currency: "EUR"}}
+	expense := &Expense{
+		ID:            "expense",
+		UserID:        "submitter",
+		State:         ExpenseStateSubmitted,
+		PostID:        "dm",
+		ChannelPostID: "approval",
+		Account:       "NL91ABNA0417164300",
+		Name:          "Sam",
+		Description:   "Lunch",
+		Category:      "Meals",
+		Amount:        item.Amount,
+		Items:         []LineItem{item},
+	}
+	if err := p.kvstore.SaveExpense(expense); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	if err := p.kvstore.SaveDraft("submitter", &Draft{
+		UserID:    "submitter",
+		State:     DraftStateConfirm,
+		ExpenseID: "expense",
+		Data:      map[string]string{"iban": expense.Account, "name": expense.Name, "description": "Dinner", "category": "meals"},
+		Items:     []LineItem{{Description: "Dinner", Amount: Money{Value: 3000, Currency: "EUR"}}},
+	}); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+
+	// Two clicks on submit read the same draft
+	first, _ := p.kvstore.GetDraft("submitter")
+	second, _ := p.kvstore.GetDraft("submitter")
+	if _, err := p.submitDraft("submitter", first); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	if _, err := p.submitDraft("submitter", second); err != errDraftChanged {
+		t.Logf("expected the draft to have changed, got %v", err)
+		t.Fail()
+	}
+
+	saved, err := p.kvstore.GetExpense("expense")
+	if err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	if len(saved.Edits) != 1 || saved.Description != "Dinner" {
+		t.Logf("expected the edit to be saved once, got %+v", saved)
+		t.Fail()
+	}
+	if draft, _ := p.kvstore.GetDraft("submitter"); draft != nil {
+		t.Logf("expected the draft to be gone, got %+v", draft)
+		t.Fail()
+	}
+}
+
+func TestSubmitDraftChecksCategory(t *testing.T) {
+	for name, tc := range map[string]struct {
+		amount  int64
+		fileIDs []string
+	}{
+		"over maximum":  {amount: 300000, fileIDs: []string{"receipt"}},
+		"no receipt":    {amount: 2000},
+		"both violated": {amount: 300000},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			data := map[string][]byte{}
+			mockKV(api, data)
+			api.On("GetDirectChannel", mock.AnythingOfType("string"), "submitter").Return(&model.Channel{Id: "direct"}, nil).Maybe()
+			var messages []string
+			api.On("CreatePost", mock.Anything).Return(func(post *model.Post) (*model.Post, *model.AppError) {
+				messages = append(messages, post.Message)
+				return post, nil
+			}).Maybe()
+
+			p := &Plugin{}
+			p.SetAPI(api)
+			p.kvstore = Store{api: api, draftExpiry: func() time.Duration { return 0 }}
+			config := &configuration{Categories: `[{"name": "Meals", "max_amount": "50", "receipt_required": true}]`}
+			if err := config.prepare(); err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			p.setConfiguration(config)
+
+			// The category was switched on the review step, after the items and files were checked
+			if err := p.kvstore.SaveDraft("submitter", &Draft{
+				UserID:  "submitter",
+				State:   DraftStateConfirm,
+				Data:    map[string]string{"iban": "NL91ABNA0417164300", "name": "Sam", "description": "Dinner", "category": "Meals"},
+				Items:   []LineItem{{Description: "Dinner", Amount: Money{Value: tc.amount, Currency: "EUR"}}},
+				FileIDs: tc.fileIDs,
+			}); err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			draft, _ := p.kvstore.GetDraft("submitter")
+			if _, err := p.submitDraft("submitter", draft); err == nil {
+				t.Logf("expected the category rules to stop the claim")
+				t.Fail()
+			}
+			if len(messages) != 1 || !strings.Contains(messages[0], "Type ```edit") {
+				t.Logf("expected the user to be told what to change, got %q", messages)
+				t.Fail()
+			}
+			if draft, _ := p.kvstore.GetDraft("submitter"); draft == nil {
+				t.Logf("expected the draft to be kept")
+				t.Fail()
+			}
+			for key := range data {
+				if strings.HasPrefix(key, "expense:") {
+					t.Logf("expected no expense to be saved, got %s", key)
+					t.Fail()
+				}
+			}
+		})
+	}
+}
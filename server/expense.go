@@ -2,67 +2,201 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/pkg/errors"
 )
 
-func (p *Plugin) createExpense(userID string, draft *Draft) error {
-	expense := &Expense{
-		ID:          model.NewId(),
+// expenseFromDraft returns a new expense holding the data of the draft.
+func (p *Plugin) expenseFromDraft(draft *Draft) (*Expense, error) {
+	items := draft.Items
+	if len(items) == 0 && draft.Data["amount"] != "" {
+		// Drafts started before line items hold a single amount
+		currency := draft.Data["currency"]
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		amount, err := parseMoney(draft.Data["amount"], currency)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse amount")
+		}
+		converted, conversion, err := p.convertAmount(amount)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert amount")
+		}
+		items = []LineItem{{Description: draft.Data["description"], Amount: converted, Conversion: conversion}}
+	}
+	if len(items) == 0 {
+		return nil, errors.New("expense has no items")
+	}
+	return &Expense{
 		UserID:      draft.UserID,
-		State:       ExpenseStateSubmitted,
 		Account:     draft.Data["iban"],
 		Name:        draft.Data["name"],
-		Amount:      draft.Data["amount"],
+		Amount:      sumItems(items, items[0].Amount.Currency),
+		Items:       items,
 		Description: draft.Data["description"],
-		FileIDs:     []string{draft.Data["file"]},
+		Category:    draft.Data["category"],
+		FileIDs:     draft.FileIDs,
+	}, nil
+}
+
+func (p *Plugin) createExpense(userID string, draft *Draft) (*Expense, error) {
+	expense, err := p.expenseFromDraft(draft)
+	if err != nil {
+		return nil, err
 	}
+	expense.ID = model.NewId()
+	expense.CreateAt = model.GetMillis()
+	expense.State = ExpenseStateSubmitted
 
 	message, err := p.formatExpense(expense)
 	if err != nil {
-		return errors.Wrap(err, "failed to format expense")
+		return nil, errors.Wrap(err, "failed to format expense")
 	}
-	dm := p.sendPinnedDM(userID, message, true)
+	dm := &model.Post{
+		Message:  message,
+		IsPinned: true,
+	}
+	model.ParseSlackAttachment(dm, []*model.SlackAttachment{{Actions: userActions(expense)}})
+	dm = p.sendPostDM(userID, dm)
 	if dm == nil {
-		return errors.New("failed to create post")
+		return nil, errors.New("failed to create post")
 	}
 
 	expense.PostID = dm.Id
+	if err = p.sendChannelMessage(expense); err != nil {
+		_ = p.API.DeletePost(dm.Id)
+		return nil, err
+	}
 	err = p.kvstore.SaveExpense(expense)
 	if err != nil {
-		return errors.Wrap(err, "failed to save expense")
+		// Don't leave posts with buttons for a claim that doesn't exist
+		_ = p.API.DeletePost(expense.ChannelPostID)
+		_ = p.API.DeletePost(dm.Id)
+		return nil, errors.Wrap(err, "failed to save expense")
 	}
-
-	return p.sendChannelMessage(expense)
+	return expense, nil
 }
 
 func (p *Plugin) formatExpense(expense *Expense) (string, error) {
 	var state string
 	switch expense.State {
+	case "":
+		state = ":memo: **Not submitted yet**"
 	case ExpenseStateSubmitted:
 		state = ":hourglass_flowing_sand: **Submitted**"
+	case ExpenseStateApproved:
+		state = ":thumbsup: **Approved**"
 	case ExpenseStatePaid:
 		state = ":white_check_mark: **Paid**"
 	case ExpenseStateRejected:
 		state = ":x: **Rejected**"
+	case ExpenseStateCancelled:
+		state = ":no_entry_sign: **Cancelled**"
 	}
-	file, appErr := p.API.GetFileInfo(expense.FileIDs[0])
-	if appErr != nil {
-		return "", errors.Wrap(appErr, "failed to get file")
+	amount := expense.Amount.String()
+	if amount == "" {
+		amount = expense.LegacyAmount
 	}
-	message := fmt.Sprintf("|Status|%s|\n|-|-|\n|Bank account|%s|\n|Name|%s|\n|Amount|%s|\n|Description|%s|\n|File|[%s](%s)|\n",
+	files, err := p.formatFiles(expense.FileIDs)
+	if err != nil {
+		return "", err
+	}
+	fileLabel := "File"
+	if len(expense.FileIDs) > 1 {
+		fileLabel = "Files"
+	}
+	message := fmt.Sprintf("|Status|%s|\n|-|-|\n|Bank account|%s|\n|Name|%s|\n|Amount|%s|\n|Description|%s|\n|%s|%s|\n",
 		state,
 		expense.Account,
 		expense.Name,
-		expense.Amount,
+		amount,
 		expense.Description,
-		file.Name,
-		fmt.Sprintf("%s/api/v4/files/%s", p.getBaseURL(), file.Id),
+		fileLabel,
+		files,
 	)
+	if len(expense.Edits) > 0 {
+		edited := time.UnixMilli(expense.Edits[len(expense.Edits)-1].CreateAt).Format(time.DateOnly)
+		message = strings.Replace(message, "|Bank account|", fmt.Sprintf("|Edited|%s (%d times)|\n|Bank account|", edited, len(expense.Edits)), 1)
+	}
+	if expense.RejectionReason != "" {
+		message = strings.Replace(message, "|Bank account|", fmt.Sprintf("|Reason|%s|\n|Bank account|", strings.ReplaceAll(expense.RejectionReason, "\n", " ")), 1)
+	}
+	if expense.PaymentBatchID != "" && expense.State == ExpenseStateApproved {
+		message = strings.Replace(message, "|Bank account|", fmt.Sprintf("|Payment batch|%s|\n|Bank account|", expense.PaymentBatchID), 1)
+	}
+	if expense.Payment != nil {
+		message = strings.Replace(message, "|Bank account|", expense.Payment.format(expense.Amount)+"|Bank account|", 1)
+	}
+	if expense.Category != "" {
+		message = strings.Replace(message, "|Description|", fmt.Sprintf("|Category|%s|\n|Description|", expense.Category), 1)
+	}
+	if len(expense.Items) > 0 {
+		items, err := p.formatItems(expense.Items, expense.Amount.Currency)
+		if err != nil {
+			return "", err
+		}
+		message += "\n" + items
+	}
 	return message, nil
 }
 
+// userActions returns the buttons for the pinned DM of the expense.
+func userActions(expense *Expense) []*model.PostAction {
+	if expense.State != ExpenseStateSubmitted {
+		return []*model.PostAction{}
+	}
+	return []*model.PostAction{{
+		Id:   "edit",
+		Name: "Edit",
+		Type: model.PostActionTypeButton,
+		Integration: &model.PostActionIntegration{
+			URL: fmt.Sprintf("%s/api/expenses/%s/edit", pluginURL, expense.ID),
+		},
+	}, {
+		Id:    "cancel",
+		Name:  "Cancel",
+		Type:  model.PostActionTypeButton,
+		Style: "danger",
+		Integration: &model.PostActionIntegration{
+			URL: fmt.Sprintf("%s/api/expenses/%s/cancel", pluginURL, expense.ID),
+		},
+	}}
+}
+
+// formatFiles renders a Markdown link to each file.
+func (p *Plugin) formatFiles(fileIDs []string) (string, error) {
+	links := make([]string, 0, len(fileIDs))
+	for _, fileID := range fileIDs {
+		file, appErr := p.API.GetFileInfo(fileID)
+		if appErr != nil {
+			return "", errors.Wrap(appErr, "failed to get file")
+		}
+		links = append(links, fmt.Sprintf("[%s](%s)", file.Name, p.fileURL(file.Id)))
+	}
+	return strings.Join(links, ", "), nil
+}
+
+func (p *Plugin) fileURL(fileID string) string {
+	return fmt.Sprintf("%s/api/v4/files/%s", p.getBaseURL(), fileID)
+}
+
+// channelTitle returns the title of the approval post, mentioning the approver of the category.
+func (p *Plugin) channelTitle(expense *Expense) (string, error) {
+	user, appErr := p.API.GetUser(expense.UserID)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get user")
+	}
+	title := fmt.Sprintf("**Expense claim from %s %s**", user.FirstName, user.LastName)
+	if category := p.getConfiguration().getCategory(expense.Category); category != nil && category.Approver != "" {
+		title += fmt.Sprintf(" for @%s", strings.TrimPrefix(category.Approver, "@"))
+	}
+	return title, nil
+}
+
 func (p *Plugin) getBaseURL() string {
 	cfg := p.API.GetConfig()
 	if cfg == nil || cfg.ServiceSettings.SiteURL == nil {
@@ -72,19 +206,51 @@ func (p *Plugin) getBaseURL() string {
 	return *cfg.ServiceSettings.SiteURL
 }
 
+// sendChannelMessage posts the expense in the approval channel of its category.
 func (p *Plugin) sendChannelMessage(expense *Expense) error {
-	channel, appErr := p.API.GetChannel(p.getConfiguration().ChannelID)
+	post, err := p.createExpensePost(expense, p.approvalChannelID(expense))
+	if err != nil {
+		return err
+	}
+	expense.ChannelPostID = post.Id
+	return nil
+}
+
+// approvalChannelID returns the channel the expense is approved in, which depends on its category.
+func (p *Plugin) approvalChannelID(expense *Expense) string {
+	config := p.getConfiguration()
+	if category := config.getCategory(expense.Category); category != nil && category.ChannelID != "" {
+		return category.ChannelID
+	}
+	return config.ChannelID
+}
+
+// sendFinanceMessage posts the approved expense in the finance channel, if one is configured.
+func (p *Plugin) sendFinanceMessage(expense *Expense) error {
+	channelID := p.getConfiguration().FinanceChannelID
+	if channelID == "" {
+		return nil
+	}
+	post, err := p.createExpensePost(expense, channelID)
+	if err != nil {
+		return err
+	}
+	expense.FinancePostID = post.Id
+	return nil
+}
+
+func (p *Plugin) createExpensePost(expense *Expense, channelID string) (*model.Post, error) {
+	channel, appErr := p.API.GetChannel(channelID)
 	if appErr != nil {
-		return errors.Wrap(appErr, "failed to get channel")
+		return nil, errors.Wrap(appErr, "failed to get channel")
 	}
-	user, appError := p.API.GetUser(expense.UserID)
-	if appError != nil {
-		return errors.Wrap(appError, "failed to get user")
+	title, err := p.channelTitle(expense)
+	if err != nil {
+		return nil, err
 	}
-	title := fmt.Sprintf("**Expense claim from %s %s**", user.FirstName, user.LastName)
 	message, err := p.formatExpense(expense)
 	if err != nil {
-		return errors.Wrap(err, "failed to format expense")
+		return nil, errors.Wrap(err, "failed to format expense")
 	}
 	post, appErr := p.API.CreatePost(&model.Post{
 		UserId:    p.botID,
@@ -92,36 +258,53 @@ func (p *Plugin) sendChannelMessage(expense *Expense) error {
 		Message:   fmt.Sprintf("%s\n\n%s", title, message),
 	})
 	if appErr != nil {
-		return errors.Wrap(appErr, "failed to create post")
-	}
-	actions := []*model.PostAction{
-		{
-			Id:    "paid",
-			Name:  "Paid",
-			Type:  model.PostActionTypeButton,
-			Style: "success",
-			Integration: &model.PostActionIntegration{
-				URL: fmt.Sprintf("/plugins/com.mattermost.plugin-expense-bot/api/expenses/%s/%s", expense.ID, ExpenseStatePaid),
-			},
-		},
-		{
-			Id:    "reject",
-			Name:  "Reject",
-			Type:  model.PostActionTypeButton,
-			Style: "danger",
-			Integration: &model.PostActionIntegration{
-				URL: fmt.Sprintf("/plugins/com.mattermost.plugin-expense-bot/api/expenses/%s/%s", expense.ID, ExpenseStateRejected),
-			},
-		},
+		return nil, errors.Wrap(appErr, "failed to create post")
 	}
 	attachment := []*model.SlackAttachment{{
 		AuthorName: "",
-		Actions:    actions,
+		Actions:    p.expenseActions(expense, post.Id),
 	}}
 	model.ParseSlackAttachment(post, attachment)
-	_, appErr = p.API.UpdatePost(post)
+	post, appErr = p.API.UpdatePost(post)
 	if appErr != nil {
-		return errors.Wrap(appErr, "failed to update post")
+		return nil, errors.Wrap(appErr, "failed to update post")
 	}
-	return nil
+	return post, nil
+}
+
+// expenseActions returns the buttons for the channel post of the expense. Approved expenses are
+// paid from the finance post when there is one, so the approval post has no buttons then.
+func (p *Plugin) expenseActions(expense *Expense, postID string) []*model.PostAction {
+	var states []string
+	switch expense.State {
+	case ExpenseStateSubmitted:
+		states = []string{ExpenseStateApproved, ExpenseStateRejected}
+	case ExpenseStateApproved:
+		if expense.FinancePostID == "" || expense.FinancePostID == postID {
+			states = []string{ExpenseStatePaid, ExpenseStateRejected}
+		}
+	}
+	actions := make([]*model.PostAction, 0, len(states))
+	for _, state := range states {
+		actions = append(actions, expenseAction(expense, state))
+	}
+	return actions
+}
+
+func expenseAction(expense *Expense, state string) *model.PostAction {
+	action := &model.PostAction{
+		Type: model.PostActionTypeButton,
+		Integration: &model.PostActionIntegration{
+			URL: fmt.Sprintf("%s/api/expenses/%s/%s", pluginURL, expense.ID, state),
+		},
+	}
+	switch state {
+	case ExpenseStateApproved:
+		action.Id, action.Name, action.Style = "approve", "Approve", "primary"
+	case ExpenseStatePaid:
+		action.Id, action.Name, action.Style = "paid", "Paid", "success"
+	case ExpenseStateRejected:
+		action.Id, action.Name, action.Style = "reject", "Reject", "danger"
+	}
+	return action
 }
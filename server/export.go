@@ -0,0 +1,345 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	exportFormatCSV  = "csv"
+	exportFormatXLSX = "xlsx"
+)
+
+// exportColumns is the header of an export. Each line item of an expense is a row, expenses without
+// items take a single row.
+var exportColumns = []string{
+	"ID", "Created", "Submitter", "Name", "Bank account", "State", "Category", "Description",
+	"Item", "Item category", "Item amount", "Currency", "Original amount", "Claim total",
+	"Paid on", "Payment reference", "Paid amount", "Receipts",
+}
+
+// exportNumericColumns are the columns holding decimal amounts, stored as numbers in XLSX.
+var exportNumericColumns = map[int]bool{10: true, 13: true, 16: true}
+
+// exportRequest is a parsed export command.
+type exportRequest struct {
+	filter   ExpenseFilter
+	username string
+	format   string
+}
+
+// parseExportArgs parses "[state] [from YYYY-MM-DD] [to YYYY-MM-DD] [user USERNAME] [category NAME]
+// [csv|xlsx]".
+func parseExportArgs(args []string) (*exportRequest, error) {
+	request := &exportRequest{format: exportFormatCSV}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := normalizeCmd(args[i])
+		switch arg {
+		case exportFormatCSV, exportFormatXLSX:
+			request.format = arg
+		case "user", "category":
+			if i+1 == len(args) {
+				return nil, errors.Errorf("missing value after %s", arg)
+			}
+			i++
+			if arg == "user" {
+				request.username = strings.TrimPrefix(strings.TrimSpace(args[i]), "@")
+			} else {
+				request.filter.Category = strings.TrimSpace(args[i])
+			}
+		case "after":
+			return nil, errors.New("exports are not paged")
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	filter, _, err := parseListArgs(rest)
+	if err != nil {
+		return nil, err
+	}
+	request.filter.State, request.filter.From, request.filter.To = filter.State, filter.From, filter.To
+	return request, nil
+}
+
+// exportArgsFromQuery turns the query of an export URL into export arguments.
+func exportArgsFromQuery(query url.Values) []string {
+	var args []string
+	if state := query.Get("state"); state != "" {
+		args = append(args, state)
+	}
+	for _, name := range []string{"from", "to", "user", "category"} {
+		if value := query.Get(name); value != "" {
+			args = append(args, name, value)
+		}
+	}
+	if format := query.Get("format"); format != "" {
+		args = append(args, format)
+	}
+	return args
+}
+
+// prepareExport resolves the user of the export request on behalf of userID, who may only export
+// their own claims unless they handle claims. It returns a message for the user when they can't
+// export.
+func (p *Plugin) prepareExport(userID string, request *exportRequest) (string, error) {
+	all, err := p.canExportAll(userID)
+	if err != nil {
+		return "", err
+	}
+	if request.username != "" {
+		user, appErr := p.API.GetUserByUsername(strings.ToLower(request.username))
+		if appErr != nil {
+			return fmt.Sprintf("User **%s** not found.", request.username), nil
+		}
+		request.filter.UserID = user.Id
+	}
+	if !all {
+		if request.filter.UserID != "" && request.filter.UserID != userID {
+			return "You can only export your own expense claims.", nil
+		}
+		request.filter.UserID = userID
+	}
+	return "", nil
+}
+
+// exportRows returns the rows of the export of the expenses, without header.
+func (p *Plugin) exportRows(expenses []*Expense) [][]string {
+	usernames := map[string]string{}
+	var rows [][]string
+	for _, expense := range expenses {
+		username, ok := usernames[expense.UserID]
+		if !ok {
+			if user, appErr := p.API.GetUser(expense.UserID); appErr == nil {
+				username = user.Username
+			}
+			usernames[expense.UserID] = username
+		}
+		created := ""
+		if expense.CreateAt != 0 {
+			created = time.UnixMilli(expense.CreateAt).Format(time.DateOnly)
+		}
+		var paidOn, reference, paid string
+		if expense.Payment != nil {
+			paidOn, reference, paid = expense.Payment.Date, expense.Payment.Reference, expense.Payment.Amount.Decimal()
+		}
+		total := expense.Amount.Decimal()
+		if expense.Amount.Currency == "" {
+			total = expense.LegacyAmount
+		}
+		receipts := make([]string, 0, len(expense.FileIDs))
+		for _, fileID := range expense.FileIDs {
+			receipts = append(receipts, p.fileURL(fileID))
+		}
+		row := []string{
+			expense.ID, created, username, expense.Name, expense.Account, expense.State, expense.Category, expense.Description,
+			"", "", "", expense.Amount.Currency, "", total,
+			paidOn, reference, paid, strings.Join(receipts, " "),
+		}
+		if len(expense.Items) == 0 {
+			rows = append(rows, row)
+			continue
+		}
+		for _, item := range expense.Items {
+			itemRow := append([]string(nil), row...)
+			itemRow[8], itemRow[9], itemRow[10] = item.Description, item.Category, item.Amount.Decimal()
+			if item.Conversion != nil {
+				itemRow[12] = item.Conversion.Original.String()
+			}
+			if item.FileID != "" {
+				itemRow[17] = p.fileURL(item.FileID)
+			}
+			rows = append(rows, itemRow)
+		}
+	}
+	return rows
+}
+
+// writeExport writes the expenses matching the request to w in the requested format.
+func (p *Plugin) writeExport(w io.Writer, request *exportRequest) error {
+	expenses, err := p.allExpenses(request.filter)
+	if err != nil {
+		return err
+	}
+	rows := append([][]string{exportColumns}, p.exportRows(expenses)...)
+	if request.format == exportFormatXLSX {
+		return writeXLSX(w, rows, exportNumericColumns)
+	}
+	for _, row := range rows {
+		for i, value := range row {
+			if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+				// Keep spreadsheets from evaluating user input as a formula
+				row[i] = "'" + value
+			}
+		}
+	}
+	writer := csv.NewWriter(w)
+	if err = writer.WriteAll(rows); err != nil {
+		return errors.Wrap(err, "failed to write CSV")
+	}
+	return nil
+}
+
+// exportFileName returns the name of an export file created at the time.
+func exportFileName(format string, now time.Time) string {
+	return fmt.Sprintf("expenses-%s.%s", now.Format("20060102-150405"), format)
+}
+
+// exportExpenses posts an export matching the arguments in the DM of the user, with a link to
+// download a fresh one. It returns a message for the user.
+func (p *Plugin) exportExpenses(userID string, args []string) (string, error) {
+	request, err := parseExportArgs(args)
+	if err != nil {
+		return fmt.Sprintf("Sorry, %s.\n\n%s", err.Error(), exportUsage), nil
+	}
+	if message, prepareErr := p.prepareExport(userID, request); prepareErr != nil || message != "" {
+		return message, prepareErr
+	}
+
+	var buf bytes.Buffer
+	if err = p.writeExport(&buf, request); err != nil {
+		return "", err
+	}
+	channel, appErr := p.API.GetDirectChannel(p.botID, userID)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get direct channel")
+	}
+	fileInfo, appErr := p.API.UploadFile(buf.Bytes(), channel.Id, exportFileName(request.format, time.Now()))
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to upload export")
+	}
+	query := url.Values{"format": {request.format}}
+	if request.filter.State != "" {
+		query.Set("state", request.filter.State)
+	}
+	if !request.filter.From.IsZero() {
+		query.Set("from", request.filter.From.Format(time.DateOnly))
+	}
+	if !request.filter.To.IsZero() {
+		query.Set("to", request.filter.To.AddDate(0, 0, -1).Format(time.DateOnly))
+	}
+	if request.username != "" {
+		query.Set("user", request.username)
+	}
+	if request.filter.Category != "" {
+		query.Set("category", request.filter.Category)
+	}
+	post := &model.Post{
+		Message: fmt.Sprintf("Here is your export. [Download an up to date one](%s%s/api/export?%s) any time.", p.getBaseURL(), pluginURL, query.Encode()),
+		FileIds: []string{fileInfo.Id},
+	}
+	if p.sendPostDM(userID, post) == nil {
+		return "", errors.New("failed to post export")
+	}
+	return "I've sent you the export in a direct message.", nil
+}
+
+// ExportExpenses streams the expenses matching the filter of the query as a file.
+func (p *Plugin) ExportExpenses(w http.ResponseWriter, r *http.Request) {
+	request, err := parseExportArgs(exportArgsFromQuery(r.URL.Query()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	message, err := p.prepareExport(r.Header.Get("Mattermost-User-ID"), request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		http.Error(w, message, http.StatusForbidden)
+		return
+	}
+
+	contentType := "text/csv"
+	if request.format == exportFormatXLSX {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(request.format, time.Now())))
+	if err = p.writeExport(w, request); err != nil {
+		// The headers are sent, all we can do is log
+		p.API.LogError("failed to export expenses", "err", err.Error())
+	}
+}
+
+const exportUsage = "Usage: `/expense export [submitted|approved|paid|rejected|cancelled] [from YYYY-MM-DD] [to YYYY-MM-DD] [user USERNAME] [category NAME] [csv|xlsx]`"
+
+// writeXLSX writes the rows as the single sheet of an XLSX workbook. Values in the numeric columns
+// are stored as numbers, everything else as text.
+func writeXLSX(w io.Writer, rows [][]string, numeric map[int]bool) error {
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Expenses" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+	}
+	for _, file := range files {
+		fw, err := archive.Create(file.name)
+		if err != nil {
+			return errors.Wrap(err, "failed to create XLSX part")
+		}
+		if _, err = io.WriteString(fw, file.content); err != nil {
+			return errors.Wrap(err, "failed to write XLSX part")
+		}
+	}
+
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return errors.Wrap(err, "failed to create XLSX sheet")
+	}
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sb.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		sb.WriteString(fmt.Sprintf(`<row r="%d">`, i+1))
+		for j, value := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			if _, numErr := strconv.ParseFloat(value, 64); i > 0 && numeric[j] && numErr == nil {
+				sb.WriteString(fmt.Sprintf(`<c r="%s"><v>%s</v></c>`, ref, value))
+				continue
+			}
+			var escaped strings.Builder
+			if err = xml.EscapeText(&escaped, []byte(value)); err != nil {
+				return errors.Wrap(err, "failed to escape XLSX cell")
+			}
+			sb.WriteString(fmt.Sprintf(`<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, escaped.String()))
+		}
+		sb.WriteString("</row>")
+	}
+	sb.WriteString("</sheetData></worksheet>")
+	if _, err = io.WriteString(sheet, sb.String()); err != nil {
+		return errors.Wrap(err, "failed to write XLSX sheet")
+	}
+	return errors.Wrap(archive.Close(), "failed to close XLSX")
+}
+
+// xlsxColumn returns the letters of the zero based column, A to Z, then AA and so on.
+func xlsxColumn(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
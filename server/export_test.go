@@ -0,0 +1,85 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExportArgs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args        string
+		expected    exportRequest
+		expectedErr bool
+	}{
+		"no args":       {expected: exportRequest{format: exportFormatCSV}},
+		"all filters":   {args: "paid from 2024-01-01 to 2024-01-31 user @alice category Travel XLSX", expected: exportRequest{format: exportFormatXLSX, username: "alice", filter: ExpenseFilter{State: ExpenseStatePaid, Category: "Travel", From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local), To: time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)}}},
+		"missing user":  {args: "user", expectedErr: true},
+		"unknown state": {args: "sent", expectedErr: true},
+		"paged":         {args: "after abc", expectedErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			request, err := parseExportArgs(strings.Fields(tc.args))
+			if tc.expectedErr {
+				if err == nil {
+					t.Logf("expected an error")
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if *request != tc.expected {
+				t.Logf("expected %+v, got %+v", tc.expected, *request)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	rows := [][]string{{"Description", "Amount"}, {"Lunch & <drinks>", "12.50"}, {"Legacy", "about 10"}}
+	if err := writeXLSX(&buf, rows, map[int]bool{1: true}); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Logf("expected a zip archive, got %v", err)
+		t.FailNow()
+	}
+	var sheet string
+	for _, file := range archive.File {
+		if file.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		r, _ := file.Open()
+		data, _ := io.ReadAll(r)
+		sheet = string(data)
+	}
+	for _, expected := range []string{
+		`<c r="A2" t="inlineStr"><is><t xml:space="preserve">Lunch &amp; &lt;drinks&gt;</t></is></c>`,
+		`<c r="B2"><v>12.50</v></c>`,
+		`<c r="B3" t="inlineStr"><is><t xml:space="preserve">about 10</t></is></c>`,
+	} {
+		if !strings.Contains(sheet, expected) {
+			t.Logf("expected %s in sheet %s", expected, sheet)
+			t.Fail()
+		}
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for index, expected := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if actual := xlsxColumn(index); actual != expected {
+			t.Logf("expected %s for %d, got %s", expected, index, actual)
+			t.Fail()
+		}
+	}
+}
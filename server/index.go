@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Expenses are indexed by user, by state and by the month they were created in. Each index is a
+// JSON list of expense cursors, see expenseCursor, updated with compare-and-set. SaveExpense adds
+// the expense to its indexes before writing it, and removes it from the state index it left only
+// once it is written. An index therefore never misses an expense, but may list one that doesn't
+// match it, e.g. when writing the expense failed: ListExpenses reconciles this on read by checking
+// every expense against the filter.
+const (
+	indexRetries = 10
+
+	userIndexPrefix  = "index:user:"
+	stateIndexPrefix = "index:state:"
+	monthIndexPrefix = "index:month:"
+	monthsIndexKey   = "index:months"
+
+	monthFormat = "2006-01"
+)
+
+// ExpenseFilter selects expenses by user, state and creation date. Empty fields match everything,
+// To is exclusive.
+type ExpenseFilter struct {
+	UserID   string
+	State    string
+	Category string
+	From     time.Time
+	To       time.Time
+}
+
+func (f ExpenseFilter) matches(expense *Expense) bool {
+	if f.UserID != "" && expense.UserID != f.UserID {
+		return false
+	}
+	if f.State != "" && expense.State != f.State {
+		return false
+	}
+	if f.Category != "" && !strings.EqualFold(expense.Category, f.Category) {
+		return false
+	}
+	return f.createdInRange(expense.CreateAt)
+}
+
+// createdInRange tells whether the creation time, in milliseconds, is between From and To.
+func (f ExpenseFilter) createdInRange(createAt int64) bool {
+	created := time.UnixMilli(createAt)
+	if !f.From.IsZero() && created.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !created.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+func monthKey(expense *Expense) string {
+	return time.UnixMilli(expense.CreateAt).UTC().Format(monthFormat)
+}
+
+// addToIndexes adds the expense to the indexes matching it.
+func (kv Store) addToIndexes(expense *Expense) error {
+	month := monthKey(expense)
+	cursor := expenseCursor(expense)
+	for _, key := range []string{
+		userIndexPrefix + expense.UserID,
+		stateIndexPrefix + expense.State,
+		monthIndexPrefix + month,
+	} {
+		if err := kv.addToIndex(key, cursor); err != nil {
+			return err
+		}
+	}
+	return kv.addToIndex(monthsIndexKey, month)
+}
+
+// removeFromIndex removes the expense from the index stored under key.
+func (kv Store) removeFromIndex(key string, expense *Expense) error {
+	cursor := expenseCursor(expense)
+	return kv.updateIndex(key, func(entries []string) []string {
+		return slices.DeleteFunc(entries, func(entry string) bool { return entry == cursor })
+	})
+}
+
+func (kv Store) getIndex(key string) ([]string, error) {
+	data, appErr := kv.api.KVGet(key)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get index")
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var entries []string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to decode index json")
+	}
+	return entries, nil
+}
+
+// addToIndex adds the entry to the index stored under key, unless it is already there.
+func (kv Store) addToIndex(key string, entry string) error {
+	return kv.updateIndex(key, func(entries []string) []string {
+		if slices.Contains(entries, entry) {
+			return entries
+		}
+		return append(entries, entry)
+	})
+}
+
+// updateIndex replaces the index stored under key with the result of update. The index is written
+// with compare-and-set, retrying when another writer got there first.
+func (kv Store) updateIndex(key string, update func(entries []string) []string) error {
+	for attempt := 0; attempt < indexRetries; attempt++ {
+		oldData, appErr := kv.api.KVGet(key)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to get index")
+		}
+		var entries []string
+		if len(oldData) > 0 {
+			if err := json.Unmarshal(oldData, &entries); err != nil {
+				return errors.Wrap(err, "failed to decode index json")
+			}
+		}
+		newData, err := json.Marshal(update(slices.Clone(entries)))
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal index")
+		}
+		if string(newData) == string(oldData) {
+			return nil
+		}
+		ok, appErr := kv.api.KVCompareAndSet(key, oldData, newData)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to store index")
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.Errorf("failed to update index %s: too many concurrent updates", key)
+}
+
+// candidateCursors returns the cursors of the expenses that may match the filter, newest first,
+// using the most selective index available.
+func (kv Store) candidateCursors(filter ExpenseFilter) ([]string, error) {
+	var cursors []string
+	switch {
+	case filter.UserID != "":
+		var err error
+		if cursors, err = kv.getIndex(userIndexPrefix + filter.UserID); err != nil {
+			return nil, err
+		}
+	case filter.State != "":
+		var err error
+		if cursors, err = kv.getIndex(stateIndexPrefix + filter.State); err != nil {
+			return nil, err
+		}
+	default:
+		months, err := kv.getIndex(monthsIndexKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, month := range months {
+			start, err := time.Parse(monthFormat, month)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid month %s in index", month)
+			}
+			if (!filter.To.IsZero() && !start.Before(filter.To)) ||
+				(!filter.From.IsZero() && start.AddDate(0, 1, 0).Before(filter.From)) {
+				continue
+			}
+			monthCursors, err := kv.getIndex(monthIndexPrefix + month)
+			if err != nil {
+				return nil, err
+			}
+			cursors = append(cursors, monthCursors...)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(cursors)))
+	return slices.Compact(cursors), nil
+}
+
+// ListExpenses returns up to limit expenses matching the filter, newest first, starting after the
+// cursor. The returned cursor is empty on the last page and can be passed to get the next page.
+// Only the expenses from the cursor on are read, the ones before it are skipped using the index.
+func (kv Store) ListExpenses(filter ExpenseFilter, cursor string, limit int) ([]*Expense, string, error) {
+	cursors, err := kv.candidateCursors(filter)
+	if err != nil {
+		return nil, "", err
+	}
+	start := 0
+	if cursor != "" {
+		start = sort.Search(len(cursors), func(i int) bool { return cursors[i] < cursor })
+	}
+	var expenses []*Expense
+	for i := start; i < len(cursors); i++ {
+		if limit > 0 && len(expenses) == limit {
+			return expenses, cursors[i-1], nil
+		}
+		createAt, id, err := parseExpenseCursor(cursors[i])
+		if err != nil {
+			return nil, "", err
+		}
+		if !filter.createdInRange(createAt) {
+			continue
+		}
+		expense, err := kv.GetExpense(id)
+		if err != nil {
+			return nil, "", err
+		}
+		if expense != nil && filter.matches(expense) {
+			expenses = append(expenses, expense)
+		}
+	}
+	return expenses, "", nil
+}
+
+// expenseCursor orders expenses by creation time, with the ID breaking ties. The creation time is
+// zero padded so cursors compare as strings.
+func expenseCursor(expense *Expense) string {
+	return fmt.Sprintf("%020s:%s", strconv.FormatInt(expense.CreateAt, 10), expense.ID)
+}
+
+// parseExpenseCursor returns the creation time and the ID of the expense of the cursor.
+func parseExpenseCursor(cursor string) (int64, string, error) {
+	createAt, id, ok := strings.Cut(cursor, ":")
+	if !ok {
+		return 0, "", errors.Errorf("invalid cursor %s", cursor)
+	}
+	millis, err := strconv.ParseInt(createAt, 10, 64)
+	if err != nil {
+		return 0, "", errors.Errorf("invalid cursor %s", cursor)
+	}
+	return millis, id, nil
+}
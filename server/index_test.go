@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+// mockKV backs the KV methods of the API with the map.
+func mockKV(api *plugintest.API, data map[string][]byte) {
+	api.On("KVGet", mock.AnythingOfType("string")).Return(func(key string) ([]byte, *model.AppError) {
+		return data[key], nil
+	}).Maybe()
+	api.On("KVSet", mock.AnythingOfType("string"), mock.Anything).Return(func(key string, value []byte) *model.AppError {
+		data[key] = value
+		return nil
+	}).Maybe()
+	api.On("KVCompareAndSet", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(func(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+		if !bytes.Equal(data[key], oldValue) {
+			return false, nil
+		}
+		data[key] = newValue
+		return true, nil
+	}).Maybe()
+	api.On("KVDelete", mock.AnythingOfType("string")).Return(func(key string) *model.AppError {
+		delete(data, key)
+		return nil
+	}).Maybe()
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(func(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+		if options.Atomic && !bytes.Equal(data[key], options.OldValue) {
+			return false, nil
+		}
+		if value == nil {
+			delete(data, key)
+		} else {
+			data[key] = value
+		}
+		return true, nil
+	}).Maybe()
+	api.On("KVCompareAndDelete", mock.AnythingOfType("string"), mock.Anything).Return(func(key string, oldValue []byte) (bool, *model.AppError) {
+		if !bytes.Equal(data[key], oldValue) {
+			return false, nil
+		}
+		delete(data, key)
+		return true, nil
+	}).Maybe()
+}
+
+// newTestStore returns a store backed by a map.
+func newTestStore() (Store, *plugintest.API, map[string][]byte) {
+	api := &plugintest.API{}
+	data := map[string][]byte{}
+	mockKV(api, data)
+	return Store{api: api, draftExpiry: func() time.Duration { return 0 }}, api, data
+}
+
+// expenseReads counts the expense records read from the API.
+func expenseReads(api *plugintest.API) int {
+	reads := 0
+	for _, call := range api.Calls {
+		if call.Method == "KVGet" && strings.HasPrefix(call.Arguments.String(0), "expense:") {
+			reads++
+		}
+	}
+	return reads
+}
+
+func TestListExpenses(t *testing.T) {
+	kv, api, _ := newTestStore()
+	for i := 0; i < 25; i++ {
+		expense := &Expense{
+			ID:       fmt.Sprintf("expense%02d", i),
+			UserID:   "user",
+			State:    ExpenseStateSubmitted,
+			CreateAt: int64(i) * 1000,
+		}
+		if i%5 == 0 {
+			expense.State = ExpenseStatePaid
+		}
+		if i == 24 {
+			expense.UserID = "other"
+		}
+		if err := kv.SaveExpense(expense); err != nil {
+			t.Logf("expected no error, got %v", err)
+			t.FailNow()
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		filter   ExpenseFilter
+		expected int
+	}{
+		"user":           {filter: ExpenseFilter{UserID: "user"}, expected: 24},
+		"state":          {filter: ExpenseFilter{State: ExpenseStatePaid}, expected: 5},
+		"user and state": {filter: ExpenseFilter{UserID: "user", State: ExpenseStateSubmitted}, expected: 19},
+		"everyone":       {filter: ExpenseFilter{}, expected: 25},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var listed []*Expense
+			cursor := ""
+			for page := 0; page == 0 || cursor != ""; page++ {
+				api.Calls = nil
+				expenses, next, err := kv.ListExpenses(tc.filter, cursor, 10)
+				if err != nil {
+					t.Logf("expected no error, got %v", err)
+					t.FailNow()
+				}
+				if reads := expenseReads(api); reads > 10+tc.expected/4 {
+					t.Logf("expected page %d to skip the expenses before the cursor, read %d", page, reads)
+					t.Fail()
+				}
+				listed = append(listed, expenses...)
+				cursor = next
+			}
+			if len(listed) != tc.expected {
+				t.Logf("expected %d expenses, got %d", tc.expected, len(listed))
+				t.FailNow()
+			}
+			for i, expense := range listed {
+				if !tc.filter.matches(expense) {
+					t.Logf("expense %s doesn't match the filter", expense.ID)
+					t.Fail()
+				}
+				if i > 0 && expense.CreateAt >= listed[i-1].CreateAt {
+					t.Logf("expected newest first, got %s after %s", expense.ID, listed[i-1].ID)
+					t.Fail()
+				}
+			}
+		})
+	}
+}
+
+func TestSaveExpenseMovesStateIndex(t *testing.T) {
+	kv, _, _ := newTestStore()
+	expense := &Expense{ID: "expense", UserID: "user", State: ExpenseStateSubmitted, CreateAt: 1000}
+	if err := kv.SaveExpense(expense); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	expense.State = ExpenseStateApproved
+	if err := kv.SaveExpense(expense); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+
+	// A stale copy fails to save, but may leave the expense in the index of its state
+	stale := *expense
+	stale.Version--
+	stale.State = ExpenseStateRejected
+	if err := kv.SaveExpense(&stale); err != ErrExpenseConflict {
+		t.Logf("expected conflict, got %v", err)
+		t.FailNow()
+	}
+
+	for state, expected := range map[string]int{
+		ExpenseStateSubmitted: 0,
+		ExpenseStateApproved:  1,
+		ExpenseStateRejected:  0,
+	} {
+		expenses, _, err := kv.ListExpenses(ExpenseFilter{State: state}, "", 0)
+		if err != nil {
+			t.Logf("expected no error, got %v", err)
+			t.FailNow()
+		}
+		if len(expenses) != expected {
+			t.Logf("expected %d %s expenses, got %d", expected, state, len(expenses))
+			t.Fail()
+		}
+	}
+}
+
+func TestUpdateIndexRetries(t *testing.T) {
+	for name, tc := range map[string]struct {
+		conflicts   int
+		expectedErr bool
+	}{
+		"no conflict":          {conflicts: 0},
+		"some conflicts":       {conflicts: 3},
+		"too many conflicts":   {conflicts: indexRetries, expectedErr: true},
+		"conflicts until last": {conflicts: indexRetries - 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			data := map[string][]byte{"index": []byte(`["a"]`)}
+			conflicts := 0
+			api.On("KVGet", "index").Return(func(key string) ([]byte, *model.AppError) {
+				return data[key], nil
+			})
+			api.On("KVCompareAndSet", "index", mock.Anything, mock.Anything).Return(func(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+				if conflicts < tc.conflicts {
+					// Another writer adds an entry in between
+					conflicts++
+					data[key] = []byte(fmt.Sprintf(`["a","c%d"]`, conflicts))
+					return false, nil
+				}
+				if !bytes.Equal(data[key], oldValue) {
+					return false, nil
+				}
+				data[key] = newValue
+				return true, nil
+			})
+			kv := Store{api: api}
+
+			err := kv.addToIndex("index", "b")
+			if tc.expectedErr {
+				if err == nil {
+					t.Logf("expected error, got index %s", data["index"])
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			entries, err := kv.getIndex("index")
+			if err != nil || len(entries) != 2+min(tc.conflicts, 1) || entries[len(entries)-1] != "b" {
+				t.Logf("unexpected index %v, %v", entries, err)
+				t.Fail()
+			}
+		})
+	}
+}
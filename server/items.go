@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LineItem is a single cost on an expense claim. Amount is in the reimbursement currency.
+type LineItem struct {
+	Description string      `json:"description"`
+	Category    string      `json:"category,omitempty"`
+	Amount      Money       `json:"amount"`
+	Conversion  *Conversion `json:"conversion,omitempty"`
+	FileID      string      `json:"file_id,omitempty"`
+}
+
+// parseLineItem parses a line item written as "amount; description; category", where description
+// and category are optional. The amount is converted into the reimbursement currency.
+func (p *Plugin) parseLineItem(input string) (*LineItem, error) {
+	parts := strings.SplitN(input, ";", 3)
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	amount, err := parseMoney(parts[0], p.getConfiguration().reimbursementCurrency())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid amount")
+	}
+	converted, conversion, err := p.convertAmount(amount)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't convert %s", amount.Currency)
+	}
+	item := &LineItem{
+		Amount:     converted,
+		Conversion: conversion,
+	}
+	if len(parts) > 1 {
+		item.Description = parts[1]
+	}
+	if len(parts) > 2 {
+		item.Category = parts[2]
+	}
+	return item, nil
+}
+
+// sumItems returns the total amount of the items in the given currency.
+func sumItems(items []LineItem, currency string) Money {
+	total := Money{Currency: currency}
+	for _, item := range items {
+		total.Value += item.Amount.Value
+	}
+	return total
+}
+
+// formatItems renders the items as a Markdown table, with the total in the last row.
+func (p *Plugin) formatItems(items []LineItem, currency string) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("|#|Description|Category|Amount|Receipt|\n|-|-|-|-|-|\n")
+	for i, item := range items {
+		amount := item.Amount.String()
+		if item.Conversion != nil {
+			amount = fmt.Sprintf("%s (%s)", amount, item.Conversion)
+		}
+		receipt := ""
+		if item.FileID != "" {
+			var err error
+			if receipt, err = p.formatFiles([]string{item.FileID}); err != nil {
+				return "", err
+			}
+		}
+		sb.WriteString(fmt.Sprintf("|%d|%s|%s|%s|%s|\n", i+1, item.Description, item.Category, amount, receipt))
+	}
+	sb.WriteString(fmt.Sprintf("||**Total**||**%s**||\n", sumItems(items, currency)))
+	return sb.String(), nil
+}
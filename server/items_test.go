@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseLineItem(t *testing.T) {
+	p := &Plugin{configuration: &configuration{ExchangeRates: "USD 0.92", ExchangeRatesDate: "2024-01-31"}}
+	for name, tc := range map[string]struct {
+		input              string
+		expected           LineItem
+		expectedConversion bool
+		expectedErr        bool
+	}{
+		"amount only":        {input: "12,50", expected: LineItem{Amount: Money{Value: 1250, Currency: "EUR"}}},
+		"description":        {input: "12,50; Lunch", expected: LineItem{Description: "Lunch", Amount: Money{Value: 1250, Currency: "EUR"}}},
+		"category":           {input: " 12,50 ; Lunch ; Meals ", expected: LineItem{Description: "Lunch", Category: "Meals", Amount: Money{Value: 1250, Currency: "EUR"}}},
+		"empty description":  {input: "12,50;; Meals", expected: LineItem{Category: "Meals", Amount: Money{Value: 1250, Currency: "EUR"}}},
+		"semicolon in last":  {input: "12,50; Lunch; Meals; extra", expected: LineItem{Description: "Lunch", Category: "Meals; extra", Amount: Money{Value: 1250, Currency: "EUR"}}},
+		"converted":          {input: "100 USD; Taxi", expected: LineItem{Description: "Taxi", Amount: Money{Value: 9200, Currency: "EUR"}}, expectedConversion: true},
+		"missing amount":     {input: "; Lunch", expectedErr: true},
+		"invalid amount":     {input: "lots; Lunch", expectedErr: true},
+		"unknown currency":   {input: "10 XYZ; Lunch", expectedErr: true},
+		"failed conversion":  {input: "10 CHF; Lunch", expectedErr: true},
+		"description before": {input: "Lunch; 12,50", expectedErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			item, err := p.parseLineItem(tc.input)
+			if tc.expectedErr {
+				if err == nil {
+					t.Logf("expected error, got %+v", item)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if (item.Conversion != nil) != tc.expectedConversion {
+				t.Logf("expected conversion %v, got %+v", tc.expectedConversion, item.Conversion)
+				t.Fail()
+			}
+			item.Conversion = nil
+			if *item != tc.expected {
+				t.Logf("expected %+v, got %+v", tc.expected, *item)
+				t.Fail()
+			}
+		})
+	}
+}
@@ -2,19 +2,42 @@ package main
 
 import (
 	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/pkg/errors"
 )
 
+const (
+	listPageSize      = 100
+	lastReportKey     = "report:last"
+	reminderKeyPrefix = "reminder:"
+)
+
+// ErrExpenseConflict is returned when saving an expense that was changed since it was read.
+var ErrExpenseConflict = errors.New("expense was changed by someone else")
+
 type KVStore interface {
 	GetUserDefaults(userID string) (*UserDefaults, error)
 	SaveUserDefaults(user *UserDefaults) error
+	DeleteUserDefaults(userID string) error
 	GetDraft(userID string) (*Draft, error)
 	SaveDraft(userID string, draft *Draft) error
+	MarkDraftNudged(draft *Draft) (bool, error)
+	ListDrafts() ([]*Draft, error)
 	DeleteDraft(userID string) error
+	DeleteDraftIfUnchanged(draft *Draft) (bool, error)
 	GetExpense(expenseID string) (*Expense, error)
 	SaveExpense(expense *Expense) error
+	ListExpenses(filter ExpenseFilter, cursor string, limit int) ([]*Expense, string, error)
+	GetReminderLevel(expenseID string) (int, error)
+	SaveReminderLevel(expenseID string, previous, level int) (bool, error)
+	GetLastReport() (string, error)
+	SaveLastReport(month string) error
+	Migrate() error
 }
 
 type UserDefaults struct {
@@ -27,27 +50,77 @@ type Draft struct {
 	UserID string            `json:"user_id"`
 	State  string            `json:"state"`
 	Data   map[string]string `json:"data"`
+
+	Items   []LineItem `json:"items,omitempty"`
+	FileIDs []string   `json:"file_ids,omitempty"`
+
+	// ReturnState is the step to come back to after the user jumped to a field with edit.
+	ReturnState string `json:"return_state,omitempty"`
+
+	// ExpenseID is set when the draft edits an existing expense.
+	ExpenseID string `json:"expense_id,omitempty"`
+
+	// CreateAt and UpdateAt are set by SaveDraft, NudgedAt when the user was reminded of the draft.
+	CreateAt int64 `json:"create_at,omitempty"`
+	UpdateAt int64 `json:"update_at,omitempty"`
+	NudgedAt int64 `json:"nudged_at,omitempty"`
+
+	// stored is the draft as read from the KV store, to tell whether it changed since.
+	stored []byte
 }
 
 type Expense struct {
 	ID          string   `json:"id"`
+	Version     int64    `json:"version"`
+	CreateAt    int64    `json:"create_at"`
 	PostID      string   `json:"post_id"`
 	UserID      string   `json:"user_id"`
 	State       string   `json:"state"`
 	Account     string   `json:"bank_account"`
 	Name        string   `json:"name"`
-	Amount      string   `json:"amount"`
+	Amount      Money    `json:"amount"`
 	Description string   `json:"description"`
+	Category    string   `json:"category,omitempty"`
 	FileIDs     []string `json:"file_ids"`
+
+	// ChannelPostID is the post in the approval channel, FinancePostID the one in the finance
+	// channel once the claim is approved.
+	ChannelPostID string `json:"channel_post_id,omitempty"`
+	FinancePostID string `json:"finance_post_id,omitempty"`
+
+	// Items are the costs making up the claim, Amount is their total.
+	Items []LineItem `json:"items,omitempty"`
+
+	// History records every change of state.
+	History []Transition `json:"history,omitempty"`
+
+	// Edits holds the previous versions of the claim, oldest first.
+	Edits []Edit `json:"edits,omitempty"`
+
+	// PaymentBatchID is the SEPA payment batch the claim is paid in.
+	PaymentBatchID string `json:"payment_batch_id,omitempty"`
+
+	// Payment records how the claim was paid.
+	Payment *Payment `json:"payment,omitempty"`
+
+	// RejectionReason is why the claim was rejected.
+	RejectionReason string `json:"rejection_reason,omitempty"`
+
+	// LegacyAmount holds the free text amount of claims that could not be migrated to Money.
+	LegacyAmount string `json:"legacy_amount,omitempty"`
 }
 
 type Store struct {
 	api plugin.API
+
+	// draftExpiry returns how long drafts are kept without activity, zero meaning forever.
+	draftExpiry func() time.Duration
 }
 
-func NewKVStore(api plugin.API) KVStore {
+func NewKVStore(api plugin.API, draftExpiry func() time.Duration) KVStore {
 	return Store{
-		api: api,
+		api:         api,
+		draftExpiry: draftExpiry,
 	}
 }
 
@@ -78,6 +151,14 @@ func (kv Store) SaveUserDefaults(user *UserDefaults) error {
 	return nil
 }
 
+func (kv Store) DeleteUserDefaults(userID string) error {
+	err := kv.api.KVDelete("user:" + userID)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete user defaults")
+	}
+	return nil
+}
+
 func (kv Store) GetDraft(userID string) (*Draft, error) {
 	draftData, err := kv.api.KVGet("draft:" + userID)
 	if err != nil {
@@ -90,21 +171,86 @@ func (kv Store) GetDraft(userID string) (*Draft, error) {
 	if err := json.Unmarshal(draftData, &draft); err != nil {
 		return nil, errors.Wrap(err, "failed to decode draft json")
 	}
+	draft.stored = draftData
 	return &draft, nil
 }
 
+// SaveDraft stores the draft as changed by the user now. It expires after the draft expiry, with
+// some grace so the expiry job can tell the user first.
 func (kv Store) SaveDraft(userID string, draft *Draft) error {
+	draft.UpdateAt = model.GetMillis()
+	if draft.CreateAt == 0 {
+		draft.CreateAt = draft.UpdateAt
+	}
+	return kv.storeDraft(userID, draft)
+}
+
+// MarkDraftNudged records that the user was reminded of the draft, without it counting as
+// activity. It only does when the draft is unchanged since it was read, and tells whether it did.
+func (kv Store) MarkDraftNudged(draft *Draft) (bool, error) {
+	nudged := *draft
+	nudged.NudgedAt = model.GetMillis()
+	draftData, err := json.Marshal(&nudged)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to marshal draft")
+	}
+	ok, appErr := kv.api.KVSetWithOptions("draft:"+draft.UserID, draftData, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        draft.stored,
+		ExpireInSeconds: kv.draftExpirySeconds(),
+	})
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to store draft")
+	}
+	if ok {
+		draft.NudgedAt = nudged.NudgedAt
+		draft.stored = draftData
+	}
+	return ok, nil
+}
+
+func (kv Store) storeDraft(userID string, draft *Draft) error {
 	draftData, err := json.Marshal(draft)
 	if err != nil {
 		return errors.Wrap(err, "failed to marshal draft")
 	}
-	appErr := kv.api.KVSet("draft:"+userID, draftData)
+	var appErr *model.AppError
+	if expiry := kv.draftExpirySeconds(); expiry > 0 {
+		appErr = kv.api.KVSetWithExpiry("draft:"+userID, draftData, expiry)
+	} else {
+		appErr = kv.api.KVSet("draft:"+userID, draftData)
+	}
 	if appErr != nil {
-		return errors.Wrap(err, "failed to store draft")
+		return errors.Wrap(appErr, "failed to store draft")
 	}
+	draft.stored = draftData
 	return nil
 }
 
+// draftExpirySeconds returns how long drafts are kept in the KV store, zero meaning forever.
+func (kv Store) draftExpirySeconds() int64 {
+	if expiry := kv.draftExpiry(); expiry > 0 {
+		return int64((expiry + draftExpiryGrace).Seconds())
+	}
+	return 0
+}
+
+// ListDrafts returns the drafts of all users.
+func (kv Store) ListDrafts() ([]*Draft, error) {
+	var drafts []*Draft
+	err := kv.forEachKey("draft:", func(key string) error {
+		draft, err := kv.GetDraft(strings.TrimPrefix(key, "draft:"))
+		if err != nil {
+			return err
+		}
+		if draft != nil {
+			drafts = append(drafts, draft)
+		}
+		return nil
+	})
+	return drafts, err
+}
+
 func (kv Store) DeleteDraft(userID string) error {
 	err := kv.api.KVDelete("draft:" + userID)
 	if err != nil {
@@ -113,6 +259,16 @@ func (kv Store) DeleteDraft(userID string) error {
 	return nil
 }
 
+// DeleteDraftIfUnchanged deletes the draft when nobody changed it since it was read, and tells
+// whether it did.
+func (kv Store) DeleteDraftIfUnchanged(draft *Draft) (bool, error) {
+	ok, appErr := kv.api.KVCompareAndDelete("draft:"+draft.UserID, draft.stored)
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to delete draft")
+	}
+	return ok, nil
+}
+
 func (kv Store) GetExpense(expenseID string) (*Expense, error) {
 	expenseData, appErr := kv.api.KVGet("expense:" + expenseID)
 	if appErr != nil {
@@ -128,14 +284,95 @@ func (kv Store) GetExpense(expenseID string) (*Expense, error) {
 	return &expense, nil
 }
 
+// SaveExpense stores the expense if nobody else saved it since it was read, and increments its
+// version. It returns ErrExpenseConflict otherwise, leaving the expense untouched. The indexes are
+// updated around the write, see index.go.
 func (kv Store) SaveExpense(expense *Expense) error {
+	oldData, appErr := kv.api.KVGet("expense:" + expense.ID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get expense")
+	}
+	var old *Expense
+	if len(oldData) > 0 {
+		if err := json.Unmarshal(oldData, &old); err != nil {
+			return errors.Wrap(err, "failed to decode expense json")
+		}
+	}
+	if (old == nil && expense.Version != 0) || (old != nil && old.Version != expense.Version) {
+		return ErrExpenseConflict
+	}
+
+	if err := kv.addToIndexes(expense); err != nil {
+		return err
+	}
+
+	expense.Version++
 	expenseData, err := json.Marshal(expense)
 	if err != nil {
+		expense.Version--
 		return errors.Wrap(err, "failed to marshal draft")
 	}
-	appErr := kv.api.KVSet("expense:"+expense.ID, expenseData)
+	ok, appErr := kv.api.KVCompareAndSet("expense:"+expense.ID, oldData, expenseData)
+	if appErr != nil || !ok {
+		expense.Version--
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to store expense")
+		}
+		return ErrExpenseConflict
+	}
+	if old != nil && old.State != expense.State {
+		if err := kv.removeFromIndex(stateIndexPrefix+old.State, expense); err != nil {
+			// The expense is saved, ListExpenses skips it in the index of its old state
+			kv.api.LogWarn("failed to remove expense from index", "expense_id", expense.ID, "err", err.Error())
+		}
+	}
+	return nil
+}
+
+// GetReminderLevel returns how far reminders about the expense waiting for approval went, see
+// reminderLevelReminded and reminderLevelEscalated. It is kept apart from the expense, so reminding
+// doesn't change its version under someone approving it.
+func (kv Store) GetReminderLevel(expenseID string) (int, error) {
+	levelData, appErr := kv.api.KVGet(reminderKeyPrefix + expenseID)
 	if appErr != nil {
-		return errors.Wrap(err, "failed to store expense")
+		return 0, errors.Wrap(appErr, "failed to get reminder level")
+	}
+	if len(levelData) == 0 {
+		return 0, nil
+	}
+	level, err := strconv.Atoi(string(levelData))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to decode reminder level")
+	}
+	return level, nil
+}
+
+// SaveReminderLevel stores the reminder level of the expense if it still is previous, and tells
+// whether it did.
+func (kv Store) SaveReminderLevel(expenseID string, previous, level int) (bool, error) {
+	var oldData []byte
+	if previous > 0 {
+		oldData = []byte(strconv.Itoa(previous))
+	}
+	ok, appErr := kv.api.KVCompareAndSet(reminderKeyPrefix+expenseID, oldData, []byte(strconv.Itoa(level)))
+	if appErr != nil {
+		return false, errors.Wrap(appErr, "failed to store reminder level")
+	}
+	return ok, nil
+}
+
+// GetLastReport returns the month, as YYYY-MM, of the last monthly report posted.
+func (kv Store) GetLastReport() (string, error) {
+	month, appErr := kv.api.KVGet(lastReportKey)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get last report")
+	}
+	return string(month), nil
+}
+
+func (kv Store) SaveLastReport(month string) error {
+	if appErr := kv.api.KVSet(lastReportKey, []byte(month)); appErr != nil {
+		return errors.Wrap(appErr, "failed to store last report")
 	}
 	return nil
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const listPerPage = 10
+
+var expenseStates = []string{ExpenseStateSubmitted, ExpenseStateApproved, ExpenseStatePaid, ExpenseStateRejected, ExpenseStateCancelled}
+
+// parseListArgs parses "[state] [from YYYY-MM-DD] [to YYYY-MM-DD] [after ID]". The to date is
+// inclusive, after is the ID of the last expense of the previous page.
+func parseListArgs(args []string) (ExpenseFilter, string, error) {
+	var filter ExpenseFilter
+	after := ""
+	for i := 0; i < len(args); i++ {
+		arg := normalizeCmd(args[i])
+		switch arg {
+		case "from", "to", "after":
+			if i+1 == len(args) {
+				return filter, "", errors.Errorf("missing value after %s", arg)
+			}
+			i++
+			value := args[i]
+			if arg == "after" {
+				after = value
+				continue
+			}
+			date, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+			if err != nil {
+				return filter, "", errors.Errorf("invalid date %s, use YYYY-MM-DD", value)
+			}
+			if arg == "from" {
+				filter.From = date
+			} else {
+				filter.To = date.AddDate(0, 0, 1)
+			}
+		default:
+			state := ""
+			for _, s := range expenseStates {
+				if strings.EqualFold(s, arg) {
+					state = s
+				}
+			}
+			if state == "" {
+				return filter, "", errors.Errorf("unknown state %s, use one of %s", args[i], strings.Join(expenseStates, ", "))
+			}
+			filter.State = state
+		}
+	}
+	return filter, after, nil
+}
+
+// listExpenses renders a page of the expenses of the user matching the arguments.
+func (p *Plugin) listExpenses(userID string, args []string) string {
+	filter, after, err := parseListArgs(args)
+	if err != nil {
+		return fmt.Sprintf("Sorry, %s.\n\n%s", err.Error(), listUsage)
+	}
+	filter.UserID = userID
+	cursor := ""
+	if after != "" {
+		expense, getErr := p.kvstore.GetExpense(after)
+		if getErr != nil {
+			p.API.LogError("failed to get expense", "err", getErr.Error())
+			return "System error, please try again."
+		}
+		if expense == nil || expense.UserID != userID {
+			return fmt.Sprintf("Sorry, you have no expense claim %s.\n\n%s", after, listUsage)
+		}
+		cursor = expenseCursor(expense)
+	}
+	expenses, next, err := p.kvstore.ListExpenses(filter, cursor, listPerPage)
+	if err != nil {
+		p.API.LogError("failed to list expenses", "err", err.Error())
+		return "System error, please try again."
+	}
+	if len(expenses) == 0 {
+		if after != "" {
+			return "There are no more expense claims."
+		}
+		if len(args) > 0 {
+			return "You have no expense claims matching that filter."
+		}
+		return "You have no expense claims yet. Type ```expense``` in a DM with ExpenseBot or use `/expense new` to submit one."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("|Date|ID|Status|Amount|Description|\n|-|-|-|-|-|\n")
+	for _, expense := range expenses {
+		date := ""
+		if expense.CreateAt != 0 {
+			date = time.UnixMilli(expense.CreateAt).Format(time.DateOnly)
+		}
+		amount := expense.Amount.String()
+		if amount == "" {
+			amount = expense.LegacyAmount
+		}
+		sb.WriteString(fmt.Sprintf("|%s|%s|%s|%s|%s|\n", date, expense.ID, expense.State, amount, expense.Description))
+	}
+	if next != "" {
+		sb.WriteString(fmt.Sprintf("\nAdd `after %s` to see more.", expenses[len(expenses)-1].ID))
+	}
+	return sb.String()
+}
+
+// allExpenses returns every expense matching the filter, newest first.
+func (p *Plugin) allExpenses(filter ExpenseFilter) ([]*Expense, error) {
+	var all []*Expense
+	cursor := ""
+	for {
+		expenses, next, err := p.kvstore.ListExpenses(filter, cursor, listPageSize)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, expenses...)
+		if next == "" {
+			return all, nil
+		}
+		cursor = next
+	}
+}
+
+const listUsage = "Usage: `list [submitted|approved|paid|rejected|cancelled] [from YYYY-MM-DD] [to YYYY-MM-DD] [after ID]`"
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/pkg/errors"
+)
+
+// migrations upgrade the stored records, in order. The index of the last applied migration plus
+// one is stored under the schema version key, so every migration runs once.
+var migrations = []func(kv Store) error{
+	migrateExpenseAmounts,
+	migrateExpenseItems,
+	migrateIndexes,
+}
+
+const schemaVersionKey = "schema_version"
+
+// Migrate applies the migrations not applied yet, under a cluster lock.
+func (kv Store) Migrate() error {
+	// Every node of a cluster activates the plugin at once, only one may migrate
+	mutex, err := cluster.NewMutex(kv.api, "migrations")
+	if err != nil {
+		return errors.Wrap(err, "failed to create migrations lock")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// Read after taking the lock, another node may have migrated in the meantime
+	versionData, appErr := kv.api.KVGet(schemaVersionKey)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get schema version")
+	}
+	version := 0
+	if len(versionData) > 0 {
+		if version, err = strconv.Atoi(string(versionData)); err != nil {
+			return errors.Wrap(err, "failed to decode schema version")
+		}
+	}
+	for ; version < len(migrations); version++ {
+		if err := migrations[version](kv); err != nil {
+			return errors.Wrapf(err, "failed to apply migration %d", version+1)
+		}
+		if appErr = kv.api.KVSet(schemaVersionKey, []byte(strconv.Itoa(version+1))); appErr != nil {
+			return errors.Wrap(appErr, "failed to store schema version")
+		}
+		kv.api.LogInfo("Applied KV store migration", "version", version+1)
+	}
+	return nil
+}
+
+// forEachKey calls fn for every key with the given prefix.
+func (kv Store) forEachKey(prefix string, fn func(key string) error) error {
+	var keys []string
+	for page := 0; ; page++ {
+		pageKeys, appErr := kv.api.KVList(page, listPageSize)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to list keys")
+		}
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		if len(pageKeys) < listPageSize {
+			break
+		}
+	}
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateExpenseAmounts converts the free text amounts of expenses into Money. Amounts that can't
+// be parsed are kept as legacy amount.
+func migrateExpenseAmounts(kv Store) error {
+	return kv.forEachKey("expense:", func(key string) error {
+		data, appErr := kv.api.KVGet(key)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to get expense")
+		}
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(data, &record); err != nil {
+			return errors.Wrap(err, "failed to decode expense json")
+		}
+		var text string
+		if err := json.Unmarshal(record["amount"], &text); err != nil {
+			return nil // not a string, already migrated
+		}
+		amount, err := parseMoney(text, defaultCurrency)
+		if err != nil {
+			kv.api.LogWarn("Failed to migrate expense amount", "key", key, "amount", text, "err", err.Error())
+			record["legacy_amount"] = record["amount"]
+		}
+		if record["amount"], err = json.Marshal(amount); err != nil {
+			return errors.Wrap(err, "failed to marshal amount")
+		}
+		if data, err = json.Marshal(record); err != nil {
+			return errors.Wrap(err, "failed to marshal expense")
+		}
+		if appErr = kv.api.KVSet(key, data); appErr != nil {
+			return errors.Wrap(appErr, "failed to store expense")
+		}
+		return nil
+	})
+}
+
+// migrateExpenseItems turns the amount of expenses without line items into a single line item.
+func migrateExpenseItems(kv Store) error {
+	return kv.forEachKey("expense:", func(key string) error {
+		data, appErr := kv.api.KVGet(key)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to get expense")
+		}
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal(data, &record); err != nil {
+			return errors.Wrap(err, "failed to decode expense json")
+		}
+		if _, ok := record["items"]; ok {
+			return nil
+		}
+		var expense struct {
+			Amount      Money  `json:"amount"`
+			Description string `json:"description"`
+		}
+		if err := json.Unmarshal(data, &expense); err != nil {
+			return errors.Wrap(err, "failed to decode expense json")
+		}
+		if expense.Amount.Value == 0 {
+			return nil // legacy amount, nothing to itemize
+		}
+		items, err := json.Marshal([]LineItem{{
+			Description: expense.Description,
+			Amount:      expense.Amount,
+		}})
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal items")
+		}
+		record["items"] = items
+		if data, err = json.Marshal(record); err != nil {
+			return errors.Wrap(err, "failed to marshal expense")
+		}
+		if appErr = kv.api.KVSet(key, data); appErr != nil {
+			return errors.Wrap(appErr, "failed to store expense")
+		}
+		return nil
+	})
+}
+
+// migrateIndexes adds every expense to the indexes, and sets the creation time of expenses created
+// before it was recorded to that of their pinned DM.
+func migrateIndexes(kv Store) error {
+	return kv.forEachKey("expense:", func(key string) error {
+		expense, err := kv.GetExpense(strings.TrimPrefix(key, "expense:"))
+		if err != nil || expense == nil {
+			return err
+		}
+		if expense.CreateAt == 0 && expense.PostID != "" {
+			if post, appErr := kv.api.GetPost(expense.PostID); appErr == nil {
+				expense.CreateAt = post.CreateAt
+			}
+		}
+		return kv.SaveExpense(expense)
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMigrate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		version  string
+		expected int
+	}{
+		"fresh":                  {expected: len(migrations)},
+		"migrated by other node": {version: strconv.Itoa(len(migrations))},
+	} {
+		t.Run(name, func(t *testing.T) {
+			kv, api, data := newTestStore()
+			if tc.version != "" {
+				data[schemaVersionKey] = []byte(tc.version)
+			}
+			api.On("KVList", mock.Anything, mock.Anything).Return(func(page, perPage int) ([]string, *model.AppError) {
+				return nil, nil
+			}).Maybe()
+			api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+			if err := kv.Migrate(); err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if version := string(data[schemaVersionKey]); version != strconv.Itoa(len(migrations)) {
+				t.Logf("expected schema version %d, got %s", len(migrations), version)
+				t.Fail()
+			}
+			applied := 0
+			locked := false
+			for _, call := range api.Calls {
+				switch {
+				case call.Method == "LogInfo":
+					applied++
+				case call.Method == "KVSetWithOptions" && strings.Contains(call.Arguments.String(0), "migrations"):
+					locked = true
+				}
+			}
+			if applied != tc.expected {
+				t.Logf("expected %d migrations to be applied, got %d", tc.expected, applied)
+				t.Fail()
+			}
+			if !locked {
+				t.Logf("expected the migrations to run under the lock")
+				t.Fail()
+			}
+			if _, ok := data["mutex_migrations"]; ok {
+				t.Logf("expected the lock to be released")
+				t.Fail()
+			}
+		})
+	}
+}
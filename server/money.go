@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const defaultCurrency = "EUR"
+
+// currencyDecimals lists the supported ISO 4217 currencies and the number of digits in their
+// minor unit.
+var currencyDecimals = map[string]int{
+	"AUD": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"CZK": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HUF": 2,
+	"JPY": 0,
+	"NOK": 2,
+	"NZD": 2,
+	"PLN": 2,
+	"SEK": 2,
+	"USD": 2,
+}
+
+var currencySymbols = map[string]string{
+	"€": "EUR",
+	"$": "USD",
+	"£": "GBP",
+	"¥": "JPY",
+}
+
+// Money is an amount in the minor unit of its currency, e.g. cents for EUR.
+type Money struct {
+	Value    int64  `json:"value"`
+	Currency string `json:"currency"`
+}
+
+// parseMoney parses user input such as "1.234,56", "1,234.56", "€ 12,50" or "12.50 USD". Both
+// comma and dot are accepted as decimal separator. When no currency is given, fallback is used.
+func parseMoney(input string, fallback string) (Money, error) {
+	s := strings.TrimSpace(input)
+	currency := ""
+	for symbol, code := range currencySymbols {
+		if strings.Contains(s, symbol) {
+			currency = code
+			s = strings.ReplaceAll(s, symbol, "")
+		}
+	}
+	fields := strings.Fields(strings.ToUpper(s))
+	var number string
+	for _, field := range fields {
+		if _, ok := currencyDecimals[field]; ok {
+			currency = field
+			continue
+		}
+		if number != "" {
+			return Money{}, errors.Errorf("unexpected %q in amount", field)
+		}
+		number = field
+	}
+	if currency == "" {
+		currency = fallback
+	}
+	decimals, ok := currencyDecimals[currency]
+	if !ok {
+		return Money{}, errors.Errorf("unsupported currency %s", currency)
+	}
+	if number == "" {
+		return Money{}, errors.New("missing amount")
+	}
+	if strings.HasPrefix(number, "-") {
+		return Money{}, errors.New("amount must be positive")
+	}
+
+	whole, fraction, err := splitDecimal(number)
+	if err != nil {
+		return Money{}, err
+	}
+	if len(fraction) > decimals {
+		return Money{}, errors.Errorf("%s has at most %d decimals", currency, decimals)
+	}
+	fraction += strings.Repeat("0", decimals-len(fraction))
+	value, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, errors.Wrap(err, "invalid amount")
+	}
+	if value <= 0 {
+		return Money{}, errors.New("amount must be positive")
+	}
+	return Money{Value: value, Currency: currency}, nil
+}
+
+// splitDecimal splits a number into its whole and fractional digits, removing thousands
+// separators. The last separator is the decimal separator, unless the same separator occurs more
+// than once or it is the only separator and is followed by exactly three digits, e.g. 1.234.567 or
+// 1,234. A whole part that is empty or starts with 0 has no thousands, so 0.500 and ,500 are
+// decimals.
+func splitDecimal(number string) (string, string, error) {
+	for _, r := range number {
+		if (r < '0' || r > '9') && r != '.' && r != ',' {
+			return "", "", errors.Errorf("invalid character %q in amount", r)
+		}
+	}
+	whole, fraction := number, ""
+	if last := strings.LastIndexAny(number, ".,"); last != -1 {
+		head, tail := number[:last], number[last+1:]
+		thousands := strings.IndexByte(head, number[last]) != -1 ||
+			(len(tail) == 3 && !strings.ContainsAny(head, ".,") && head != "" && head[0] != '0')
+		if !thousands {
+			if tail == "" {
+				return "", "", errors.New("missing decimals")
+			}
+			whole, fraction = head, tail
+		}
+	}
+	if whole == "" {
+		return "0", fraction, nil
+	}
+
+	separator := ""
+	if strings.Contains(whole, ".") {
+		separator = "."
+	}
+	if strings.Contains(whole, ",") {
+		if separator != "" {
+			return "", "", errors.New("mixed thousands separators")
+		}
+		separator = ","
+	}
+	if separator == "" {
+		return whole, fraction, nil
+	}
+	groups := strings.Split(whole, separator)
+	for i, group := range groups {
+		if (i == 0 && (group == "" || len(group) > 3)) || (i > 0 && len(group) != 3) {
+			return "", "", errors.New("invalid thousands separator")
+		}
+	}
+	return strings.Join(groups, ""), fraction, nil
+}
+
+// String formats the amount with its currency, e.g. "EUR 1,234.56".
+func (m Money) String() string {
+	if m.Currency == "" {
+		return ""
+	}
+	return m.Currency + " " + m.Format()
+}
+
+// Format formats the amount without currency, using a dot as decimal separator.
+func (m Money) Format() string {
+	decimals := currencyDecimals[m.Currency]
+	value := m.Value
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	divisor := int64(1)
+	for i := 0; i < decimals; i++ {
+		divisor *= 10
+	}
+	whole := strconv.FormatInt(value/divisor, 10)
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+	if decimals == 0 {
+		return sign + whole
+	}
+	return fmt.Sprintf("%s%s.%0*d", sign, whole, decimals, value%divisor)
+}
+
+// Decimal formats the amount as a plain decimal number, e.g. "1234.56", suitable for storing and
+// parsing again.
+func (m Money) Decimal() string {
+	return strings.ReplaceAll(m.Format(), ",", "")
+}
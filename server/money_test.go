@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input       string
+		expected    Money
+		expectedErr bool
+	}{
+		"whole number":              {input: "100", expected: Money{Value: 10000, Currency: "EUR"}},
+		"dot decimal":               {input: "100.5", expected: Money{Value: 10050, Currency: "EUR"}},
+		"comma decimal":             {input: "12,50", expected: Money{Value: 1250, Currency: "EUR"}},
+		"european thousands":        {input: "1.234,56", expected: Money{Value: 123456, Currency: "EUR"}},
+		"english thousands":         {input: "1,234.56", expected: Money{Value: 123456, Currency: "EUR"}},
+		"thousands only":            {input: "1.234", expected: Money{Value: 123400, Currency: "EUR"}},
+		"multiple thousands":        {input: "1,234,567.8", expected: Money{Value: 123456780, Currency: "EUR"}},
+		"currency code":             {input: "12.50 usd", expected: Money{Value: 1250, Currency: "USD"}},
+		"currency symbol":           {input: "€ 12,50", expected: Money{Value: 1250, Currency: "EUR"}},
+		"no minor unit":             {input: "500 JPY", expected: Money{Value: 500, Currency: "JPY"}},
+		"zero":                      {input: "0", expectedErr: true},
+		"negative":                  {input: "-5", expectedErr: true},
+		"free text":                 {input: "ten-ish euros", expectedErr: true},
+		"too many decimals":         {input: "1.2345", expectedErr: true},
+		"decimals without minor":    {input: "5.5 JPY", expectedErr: true},
+		"invalid thousands":         {input: "1,23,456", expectedErr: true},
+		"unsupported currency":      {input: "10 XYZ", expectedErr: true},
+		"missing decimals":          {input: "100.", expectedErr: true},
+		"mixed thousands separator": {input: "1.234,567.00", expectedErr: true},
+		"zero thousands":            {input: "0.500", expectedErr: true},
+		"empty thousands":           {input: ",500", expectedErr: true},
+		"zero thousands decimals":   {input: "0.500 JPY", expectedErr: true},
+		"leading zero thousands":    {input: "012,500", expectedErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			money, err := parseMoney(tc.input, "EUR")
+			if tc.expectedErr {
+				if err == nil {
+					t.Logf("expected error, got %v", money)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if money != tc.expected {
+				t.Logf("expected %v, got %v", tc.expected, money)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	for name, tc := range map[string]struct {
+		money    Money
+		expected string
+	}{
+		"cents":         {money: Money{Value: 5, Currency: "EUR"}, expected: "EUR 0.05"},
+		"thousands":     {money: Money{Value: 123456789, Currency: "EUR"}, expected: "EUR 1,234,567.89"},
+		"no minor unit": {money: Money{Value: 1500, Currency: "JPY"}, expected: "JPY 1,500"},
+		"empty":         {money: Money{}, expected: ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := tc.money.String(); got != tc.expected {
+				t.Logf("expected %q, got %q", tc.expected, got)
+				t.Fail()
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// draftFields maps the fields users can edit to the step asking for them.
+var draftFields = map[string]string{
+	"iban":        DraftStateAskAccount,
+	"account":     DraftStateAskAccount,
+	"name":        DraftStateAskName,
+	"category":    DraftStateAskCategory,
+	"items":       DraftStateAskItems,
+	"amount":      DraftStateAskItems,
+	"description": DraftStateAskDescription,
+	"files":       DraftStateAskFile,
+	"receipts":    DraftStateAskFile,
+}
+
+// draftFieldOptions are the fields offered in the edit menu of the review.
+var draftFieldOptions = []*model.PostActionOptions{
+	{Text: "Bank account", Value: "iban"},
+	{Text: "Name", Value: "name"},
+	{Text: "Category", Value: "category"},
+	{Text: "Items", Value: "items"},
+	{Text: "Description", Value: "description"},
+	{Text: "Receipts", Value: "files"},
+}
+
+// draftSteps returns the steps of the conversation in order. The category is only asked when
+// categories are configured.
+func (p *Plugin) draftSteps() []string {
+	steps := []string{DraftStateAskAccount, DraftStateAskName, DraftStateAskCategory, DraftStateAskItems, DraftStateAskDescription, DraftStateAskFile, DraftStateConfirm}
+	if len(p.getConfiguration().categories) == 0 {
+		steps = slices.DeleteFunc(steps, func(step string) bool { return step == DraftStateAskCategory })
+	}
+	return steps
+}
+
+// stepIndex returns the position of the state in the steps, or -1 when it is not a step.
+func (p *Plugin) stepIndex(state string) int {
+	if state == DraftStateAskAmount {
+		state = DraftStateAskItems
+	}
+	return slices.Index(p.draftSteps(), state)
+}
+
+// nextStep returns the step after the state.
+func (p *Plugin) nextStep(state string) string {
+	steps := p.draftSteps()
+	index := p.stepIndex(state)
+	if index < 0 || index+1 == len(steps) {
+		return DraftStateConfirm
+	}
+	return steps[index+1]
+}
+
+// moveTo moves the draft to the step, saves it and asks the question of the step. When the user
+// jumped to a field with edit, they go back to the step they were at instead.
+func (p *Plugin) moveTo(draft *Draft, state string) error {
+	if draft.ReturnState != "" {
+		state = draft.ReturnState
+		draft.ReturnState = ""
+	}
+	draft.State = state
+	if err := p.kvstore.SaveDraft(draft.UserID, draft); err != nil {
+		return errors.Wrap(err, "failed to save draft")
+	}
+	return p.askStep(draft)
+}
+
+// askStep asks the question of the step the draft is at.
+func (p *Plugin) askStep(draft *Draft) error {
+	switch draft.State {
+	case DraftStateAskAccount:
+		_ = p.sendDM(draft.UserID, "**What is your IBAN?**")
+	case DraftStateAskName:
+		_ = p.sendDM(draft.UserID, "**In what name is the account held?**")
+	case DraftStateAskCategory:
+		p.sendCategoryQuestion(draft.UserID)
+	case DraftStateAskItems, DraftStateAskAmount:
+		if len(draft.Items) == 0 {
+			_ = p.sendDM(draft.UserID, askItemsMessage)
+			return nil
+		}
+		items, err := p.formatItems(draft.Items, p.getConfiguration().reimbursementCurrency())
+		if err != nil {
+			return err
+		}
+		_ = p.sendDM(draft.UserID, items+"\nAdd an item as ```amount; description; category```, type ```remove <number>``` to remove one, or ```done``` when the items are correct.")
+	case DraftStateAskDescription:
+		_ = p.sendDM(draft.UserID, "**In a few words, describe the expense.**")
+	case DraftStateAskFile:
+		if len(draft.FileIDs) > 0 {
+			_ = p.sendDM(draft.UserID, fmt.Sprintf("You already uploaded %d file(s). Upload any other receipts, or type ```done``` when you're finished.", len(draft.FileIDs)))
+			return nil
+		}
+		_ = p.sendDM(draft.UserID, askFileMessage)
+	case DraftStateConfirm:
+		return p.sendReview(draft)
+	}
+	return nil
+}
+
+// navigate handles the back and edit commands. handled tells whether the message was one, message
+// is for the user.
+func (p *Plugin) navigate(draft *Draft, msg string) (message string, handled bool, err error) {
+	fields := strings.Fields(normalizeCmd(msg))
+	switch {
+	case len(fields) == 1 && fields[0] == "back":
+		message, err = p.goBack(draft)
+		return message, true, err
+	case len(fields) == 2 && fields[0] == "edit":
+		if _, ok := draftFields[fields[1]]; !ok && draft.State != DraftStateConfirm {
+			// Could be an answer, e.g. a name
+			return "", false, nil
+		}
+		message, err = p.editField(draft, fields[1])
+		return message, true, err
+	}
+	return "", false, nil
+}
+
+// goBack moves the draft to the step before the one it is at. It returns a message for the user
+// when there is no step to go back to.
+func (p *Plugin) goBack(draft *Draft) (string, error) {
+	index := p.stepIndex(draft.State)
+	if index < 0 {
+		return "There is no previous question to go back to. Type ```reset``` to start over.", nil
+	}
+	if index == 0 {
+		return "This is the first question. Type ```reset``` to start over.", nil
+	}
+	draft.ReturnState = ""
+	return "", p.moveTo(draft, p.draftSteps()[index-1])
+}
+
+// editField moves the draft to the step asking for the field, coming back to the current step once
+// it is answered. It returns a message for the user when the field can't be edited now.
+func (p *Plugin) editField(draft *Draft, field string) (string, error) {
+	state, ok := draftFields[normalizeCmd(field)]
+	if !ok || p.stepIndex(state) < 0 {
+		return fmt.Sprintf("I don't know the field **%s**. You can edit: %s.", field, strings.Join(p.editableFields(), ", ")), nil
+	}
+	current := p.stepIndex(draft.State)
+	if current < 0 {
+		return "Answer the current question first.", nil
+	}
+	if p.stepIndex(state) > current {
+		return "We haven't got to that question yet.", nil
+	}
+	if p.stepIndex(state) < current {
+		draft.ReturnState = draft.State
+	}
+	draft.State = state
+	if err := p.kvstore.SaveDraft(draft.UserID, draft); err != nil {
+		return "", errors.Wrap(err, "failed to save draft")
+	}
+	return "", p.askStep(draft)
+}
+
+// editableFields returns the names of the fields users can edit.
+func (p *Plugin) editableFields() []string {
+	var fields []string
+	for _, option := range draftFieldOptions {
+		if p.stepIndex(draftFields[option.Value]) >= 0 {
+			fields = append(fields, option.Value)
+		}
+	}
+	return fields
+}
+
+// formatDraft renders the draft the way its expense will look once submitted.
+func (p *Plugin) formatDraft(draft *Draft) (string, error) {
+	expense, err := p.expenseFromDraft(draft)
+	if err != nil {
+		return "", err
+	}
+	if draft.ExpenseID != "" {
+		// Show the claim being edited as it will be, rather than as a new one
+		stored, err := p.kvstore.GetExpense(draft.ExpenseID)
+		if err != nil {
+			return "", err
+		}
+		if stored != nil {
+			applyEdit(stored, expense)
+			expense = stored
+		}
+	}
+	return p.formatExpense(expense)
+}
+
+// discardedMessage tells the user their draft was discarded.
+func discardedMessage(draft *Draft) string {
+	if draft.ExpenseID != "" {
+		return fmt.Sprintf("Your changes to expense claim **%s** were discarded, the claim is unchanged.", draft.ExpenseID)
+	}
+	return "Your expense claim was discarded. Type ```expense``` to start a new expense."
+}
+
+// sendReview shows the draft to the user, with buttons to submit it, edit a field or discard it.
+// Nothing is submitted until the user confirms.
+func (p *Plugin) sendReview(draft *Draft) error {
+	message, err := p.formatDraft(draft)
+	if err != nil {
+		return err
+	}
+	options := make([]*model.PostActionOptions, 0, len(draftFieldOptions))
+	for _, option := range draftFieldOptions {
+		if p.stepIndex(draftFields[option.Value]) >= 0 {
+			options = append(options, option)
+		}
+	}
+	post := &model.Post{
+		Message: "**Please check your expense claim before submitting it.**\n\n" + message + "\nYou can also type ```submit```, ```edit <field>``` or ```cancel```.",
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Actions: []*model.PostAction{{
+			Id:    "submit",
+			Name:  "Submit",
+			Type:  model.PostActionTypeButton,
+			Style: "primary",
+			Integration: &model.PostActionIntegration{
+				URL: pluginURL + "/api/drafts/confirm",
+			},
+		}, {
+			Id:      "edit",
+			Name:    "Edit",
+			Type:    model.PostActionTypeSelect,
+			Options: options,
+			Integration: &model.PostActionIntegration{
+				URL: pluginURL + "/api/drafts/edit",
+			},
+		}, {
+			Id:    "cancel",
+			Name:  "Cancel",
+			Type:  model.PostActionTypeButton,
+			Style: "danger",
+			Integration: &model.PostActionIntegration{
+				URL: pluginURL + "/api/drafts/cancel",
+			},
+		}},
+	}})
+	if p.sendPostDM(draft.UserID, post) == nil {
+		return errors.New("failed to send review")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNextStep(t *testing.T) {
+	withCategories := &Plugin{configuration: &configuration{categories: []*Category{{Name: "Travel"}}}}
+	withoutCategories := &Plugin{configuration: &configuration{}}
+	for name, tc := range map[string]struct {
+		plugin   *Plugin
+		state    string
+		expected string
+	}{
+		"account":                 {plugin: withoutCategories, state: DraftStateAskAccount, expected: DraftStateAskName},
+		"name with categories":    {plugin: withCategories, state: DraftStateAskName, expected: DraftStateAskCategory},
+		"name without categories": {plugin: withoutCategories, state: DraftStateAskName, expected: DraftStateAskItems},
+		"legacy amount":           {plugin: withoutCategories, state: DraftStateAskAmount, expected: DraftStateAskDescription},
+		"file":                    {plugin: withoutCategories, state: DraftStateAskFile, expected: DraftStateConfirm},
+		"confirm":                 {plugin: withoutCategories, state: DraftStateConfirm, expected: DraftStateConfirm},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := tc.plugin.nextStep(tc.state); actual != tc.expected {
+				t.Logf("expected %s, got %s", tc.expected, actual)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestNavigateIgnoresAnswers(t *testing.T) {
+	p := &Plugin{configuration: &configuration{}}
+	for _, msg := range []string{"Edit Smith", "back pain", "edit"} {
+		if _, handled, _ := p.navigate(&Draft{State: DraftStateAskName}, msg); handled {
+			t.Logf("expected %q to be taken as an answer", msg)
+			t.Fail()
+		}
+	}
+}
+
+func TestFormatDraft(t *testing.T) {
+	kv, api, _ := newTestStore()
+	item := LineItem{Description: "Lunch", Amount: Money{Value: 1250, Currency: "EUR"}}
+	if err := kv.SaveExpense(&Expense{
+		ID:          "expense",
+		UserID:      "user",
+		State:       ExpenseStateSubmitted,
+		Description: "Lunch",
+		Amount:      item.Amount,
+		Items:       []LineItem{item},
+	}); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	p := &Plugin{kvstore: kv}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{})
+
+	for name, tc := range map[string]struct {
+		expenseID string
+		expected  string
+	}{
+		"new before submitting": {expected: "Not submitted yet"},
+		"editing":               {expenseID: "expense", expected: "Submitted"},
+		"deleted":               {expenseID: "deleted", expected: "Not submitted yet"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			message, err := p.formatDraft(&Draft{
+				UserID:    "user",
+				ExpenseID: tc.expenseID,
+				Data:      map[string]string{"description": "Dinner"},
+				Items:     []LineItem{{Description: "Dinner", Amount: Money{Value: 3000, Currency: "EUR"}}},
+			})
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if !strings.Contains(message, tc.expected) || !strings.Contains(message, "|Description|Dinner|") {
+				t.Logf("expected the edited claim marked %q, got %q", tc.expected, message)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestConfirmDraft(t *testing.T) {
+	api := &plugintest.API{}
+	mockKV(api, map[string][]byte{})
+	mockPosts(api, map[string]*model.Post{})
+	api.On("GetDirectChannel", mock.AnythingOfType("string"), "user").Return(&model.Channel{Id: "direct"}, nil).Maybe()
+	api.On("GetChannel", mock.AnythingOfType("string")).Return(func(channelID string) (*model.Channel, *model.AppError) {
+		return &model.Channel{Id: channelID}, nil
+	}).Maybe()
+	api.On("GetUser", "user").Return(&model.User{Id: "user", FirstName: "Sam"}, nil).Maybe()
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewPointer("http://localhost")}}).Maybe()
+	api.On("GetFileInfo", "receipt").Return(&model.FileInfo{Id: "receipt", Name: "receipt.pdf"}, nil).Maybe()
+
+	p := &Plugin{kvstore: Store{api: api, draftExpiry: func() time.Duration { return 0 }}}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{ChannelID: "expenses"})
+	if err := p.kvstore.SaveDraft("user", &Draft{
+		UserID:  "user",
+		State:   DraftStateConfirm,
+		Data:    map[string]string{"iban": "NL91ABNA0417164300", "name": "Sam", "description": "Dinner"},
+		Items:   []LineItem{{Description: "Dinner", Amount: Money{Value: 3000, Currency: "EUR"}}},
+		FileIDs: []string{"receipt"},
+	}); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/drafts/confirm", strings.NewReader(`{"user_id": "user"}`))
+	request.Header.Set("Mattermost-User-ID", "user")
+	recorder := httptest.NewRecorder()
+	p.ConfirmDraft(recorder, request)
+
+	var response model.PostActionIntegrationResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	if response.Update == nil || !strings.Contains(response.Update.Message, "Submitted") || strings.Contains(response.Update.Message, "Not submitted yet") {
+		t.Logf("expected the review post to show the submitted claim, got %+v", response.Update)
+		t.Fail()
+	}
+}
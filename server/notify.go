@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// defaultNotifications are the messages sent to the submitter when no template is configured.
+var defaultNotifications = map[string]string{
+	ExpenseStateApproved: ":thumbsup: Your expense claim of **{{.Amount}}** for **{{.Description}}** was approved{{with .Actor}} by @{{.}}{{end}}.",
+	ExpenseStatePaid:     ":white_check_mark: Your expense claim of **{{.Amount}}** for **{{.Description}}** was paid.{{if .Partial}} Only **{{.PaidAmount}}** was paid, contact finance about the rest.{{end}}",
+	ExpenseStateRejected: ":x: Your expense claim of **{{.Amount}}** for **{{.Description}}** was rejected{{with .Actor}} by @{{.}}{{end}}.\n\n> {{.Reason}}\n\nYou can resubmit a corrected claim.",
+}
+
+// notificationData is what the notification templates can refer to. Actor is empty when the user
+// who changed the claim is unknown.
+type notificationData struct {
+	Amount      string
+	Description string
+	Category    string
+	Name        string
+	State       string
+	Reason      string
+	Actor       string
+
+	// PaidAmount, PaymentDate and PaymentReference describe the payment of a paid claim, Partial
+	// tells whether less than the claimed amount was paid.
+	PaidAmount       string
+	PaymentDate      string
+	PaymentReference string
+	Partial          bool
+}
+
+// sampleNotification is the data notification templates are checked with.
+var sampleNotification = notificationData{
+	Amount:           "EUR 12.50",
+	Description:      "Lunch",
+	Category:         "Meals",
+	Name:             "Sam",
+	State:            ExpenseStatePaid,
+	Reason:           "No receipt",
+	Actor:            "alice",
+	PaidAmount:       "EUR 10.00",
+	PaymentDate:      "2024-01-31",
+	PaymentReference: "REF",
+	Partial:          true,
+}
+
+// parseNotifications parses the configured notification templates by state, using the default
+// template of the state when none is configured. Each template is tried on sample data, so a
+// mistyped placeholder is reported with the configuration instead of when notifying.
+func parseNotifications(configured map[string]string) (map[string]*template.Template, error) {
+	notifications := map[string]*template.Template{}
+	for state, text := range defaultNotifications {
+		if strings.TrimSpace(configured[state]) != "" {
+			text = configured[state]
+		}
+		tmpl, err := template.New(state).Parse(text)
+		if err == nil {
+			err = tmpl.Execute(io.Discard, sampleNotification)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s notification", strings.ToLower(state))
+		}
+		notifications[state] = tmpl
+	}
+	return notifications, nil
+}
+
+// notifyStateChange tells the submitter the user changed the state of their expense, in a reply to
+// its pinned DM. Changes made by the submitter themselves are not notified.
+func (p *Plugin) notifyStateChange(expense *Expense, userID string) {
+	if userID == expense.UserID {
+		return
+	}
+	notifications := p.getConfiguration().notifications
+	if notifications == nil {
+		// Not prepared yet, e.g. before the configuration was loaded
+		var err error
+		if notifications, err = parseNotifications(nil); err != nil {
+			p.API.LogError("failed to parse default notifications", "err", err.Error())
+			return
+		}
+	}
+	tmpl, ok := notifications[expense.State]
+	if !ok {
+		return
+	}
+
+	data := notificationData{
+		Amount:      expense.Amount.String(),
+		Description: expense.Description,
+		Category:    expense.Category,
+		Name:        expense.Name,
+		State:       expense.State,
+		Reason:      strings.ReplaceAll(expense.RejectionReason, "\n", " "),
+	}
+	if payment := expense.Payment; payment != nil {
+		data.PaidAmount = payment.Amount.String()
+		data.PaymentDate = payment.Date
+		data.PaymentReference = payment.Reference
+		data.Partial = payment.partial(expense.Amount)
+	}
+	if user, appErr := p.API.GetUser(userID); appErr == nil {
+		data.Actor = user.Username
+	}
+	var message strings.Builder
+	if err := tmpl.Execute(&message, data); err != nil {
+		p.API.LogError("failed to execute notification template", "state", expense.State, "err", err.Error())
+		return
+	}
+
+	post := &model.Post{
+		RootId:  expense.PostID,
+		Message: message.String(),
+	}
+	if expense.State == ExpenseStateRejected {
+		model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+			Actions: []*model.PostAction{{
+				Id:   "resubmit",
+				Name: "Resubmit",
+				Type: model.PostActionTypeButton,
+				Integration: &model.PostActionIntegration{
+					URL: fmt.Sprintf("%s/api/expenses/%s/resubmit", pluginURL, expense.ID),
+				},
+			}},
+		}})
+	}
+	if p.sendPostDM(expense.UserID, post) == nil {
+		p.API.LogError("failed to notify user of state change", "id", expense.ID, "state", expense.State)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNotifications(t *testing.T) {
+	data := notificationData{Amount: "EUR 12.50", Description: "Lunch", Reason: "No receipt", Actor: "alice"}
+	for name, tc := range map[string]struct {
+		configured  map[string]string
+		data        *notificationData
+		state       string
+		expected    string
+		expectedErr bool
+	}{
+		"default":        {state: ExpenseStatePaid, expected: ":white_check_mark: Your expense claim of **EUR 12.50** for **Lunch** was paid."},
+		"blank default":  {configured: map[string]string{ExpenseStatePaid: "  "}, state: ExpenseStatePaid, expected: ":white_check_mark: Your expense claim of **EUR 12.50** for **Lunch** was paid."},
+		"configured":     {configured: map[string]string{ExpenseStateRejected: "{{.Actor}}: {{.Reason}}"}, state: ExpenseStateRejected, expected: "alice: No receipt"},
+		"invalid syntax": {configured: map[string]string{ExpenseStateApproved: "{{.Amount"}, expectedErr: true},
+		"unknown field":  {configured: map[string]string{ExpenseStateApproved: "{{.Amonut}}"}, expectedErr: true},
+		"actor":          {state: ExpenseStateApproved, expected: ":thumbsup: Your expense claim of **EUR 12.50** for **Lunch** was approved by @alice."},
+		"no actor":       {data: &notificationData{Amount: "EUR 12.50", Description: "Lunch"}, state: ExpenseStateApproved, expected: ":thumbsup: Your expense claim of **EUR 12.50** for **Lunch** was approved."},
+	} {
+		t.Run(name, func(t *testing.T) {
+			notifications, err := parseNotifications(tc.configured)
+			if tc.expectedErr {
+				if err == nil {
+					t.Logf("expected an error")
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if tc.data == nil {
+				tc.data = &data
+			}
+			var message strings.Builder
+			if err = notifications[tc.state].Execute(&message, tc.data); err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if message.String() != tc.expected {
+				t.Logf("expected %q, got %q", tc.expected, message.String())
+				t.Fail()
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// Payment records the payment of an expense. Amount may be less than the claimed amount.
+type Payment struct {
+	Date      string `json:"date"`
+	Reference string `json:"reference"`
+	Amount    Money  `json:"amount"`
+}
+
+// partial tells whether less than the claimed amount was paid.
+func (p *Payment) partial(claimed Money) bool {
+	return claimed.Currency == p.Amount.Currency && p.Amount.Value < claimed.Value
+}
+
+// format renders the payment as rows of the expense table.
+func (p *Payment) format(claimed Money) string {
+	amount := p.Amount.String()
+	if p.partial(claimed) {
+		outstanding := Money{Value: claimed.Value - p.Amount.Value, Currency: claimed.Currency}
+		amount += fmt.Sprintf(" (partial, %s outstanding)", outstanding)
+	}
+	return fmt.Sprintf("|Paid on|%s|\n|Payment reference|%s|\n|Paid amount|%s|\n", p.Date, p.Reference, amount)
+}
+
+// openPaidDialog asks the user for the details of the payment of the expense. It returns a message
+// for the user instead when they can't mark it paid.
+func (p *Plugin) openPaidDialog(triggerID, userID, expenseID, postID string) (string, error) {
+	expense, err := p.kvstore.GetExpense(expenseID)
+	if err != nil {
+		return "", err
+	}
+	if expense == nil {
+		return "This expense claim no longer exists.", nil
+	}
+	if message, checkErr := p.checkStateChange(userID, expense, ExpenseStatePaid); checkErr != nil || message != "" {
+		return message, checkErr
+	}
+
+	state, err := json.Marshal(expenseDialogState{ExpenseID: expenseID, PostID: postID})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal dialog state")
+	}
+	amount := ""
+	if expense.Amount.Currency != "" {
+		amount = expense.Amount.String()
+	}
+	dialog := model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       pluginURL + "/api/dialogs/paid",
+		Dialog: model.Dialog{
+			CallbackId:       "paid",
+			Title:            "Mark expense claim paid",
+			IntroductionText: fmt.Sprintf("Paying the claim of **%s** for **%s** to **%s** (%s).", expense.Amount, expense.Description, expense.Name, expense.Account),
+			SubmitLabel:      "Paid",
+			State:            string(state),
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Payment date",
+					Name:        "date",
+					Type:        "text",
+					Default:     time.Now().Format(time.DateOnly),
+					Placeholder: "YYYY-MM-DD",
+				},
+				{
+					DisplayName: "Payment reference",
+					Name:        "reference",
+					Type:        "text",
+					HelpText:    "Bank transaction ID or reference of the transfer.",
+					MaxLength:   140,
+				},
+				{
+					DisplayName: "Paid amount",
+					Name:        "amount",
+					Type:        "text",
+					Default:     amount,
+					HelpText:    "Lower it when only part of the claim is paid.",
+				},
+			},
+		},
+	}
+	if appErr := p.API.OpenInteractiveDialog(dialog); appErr != nil {
+		return "", errors.Wrap(appErr, "failed to open dialog")
+	}
+	return "", nil
+}
+
+func (p *Plugin) submitPaidDialog(request *model.SubmitDialogRequest) (*model.SubmitDialogResponse, error) {
+	var state expenseDialogState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil {
+		return nil, errors.Wrap(err, "failed to decode dialog state")
+	}
+	expense, err := p.kvstore.GetExpense(state.ExpenseID)
+	if err != nil {
+		return nil, err
+	}
+	if expense == nil {
+		return &model.SubmitDialogResponse{Error: "This expense claim no longer exists."}, nil
+	}
+	payment, errs := validatePayment(request.Submission, expense.Amount, p.getConfiguration().reimbursementCurrency())
+	if len(errs) > 0 {
+		return &model.SubmitDialogResponse{Errors: errs}, nil
+	}
+
+	message, err := p.changeExpenseState(request.UserId, state.ExpenseID, ExpenseStatePaid, state.PostID, func(expense *Expense) {
+		expense.Payment = payment
+	})
+	if err != nil {
+		return nil, err
+	}
+	if message != "" {
+		return &model.SubmitDialogResponse{Error: message}, nil
+	}
+	return &model.SubmitDialogResponse{}, nil
+}
+
+// validatePayment returns the payment of the paid dialog submission for an expense of the claimed
+// amount, or the errors by field. The currency is used for expenses without amount.
+func validatePayment(submission map[string]any, claimed Money, currency string) (*Payment, map[string]string) {
+	errs := map[string]string{}
+	value := func(name string) string {
+		v, _ := submission[name].(string)
+		return strings.TrimSpace(v)
+	}
+
+	payment := &Payment{Reference: value("reference")}
+	date, err := time.Parse(time.DateOnly, value("date"))
+	if err != nil {
+		errs["date"] = "Please enter a date as YYYY-MM-DD."
+	} else {
+		payment.Date = date.Format(time.DateOnly)
+	}
+	if payment.Reference == "" {
+		errs["reference"] = "Please enter the payment reference."
+	}
+	if claimed.Currency != "" {
+		currency = claimed.Currency
+	}
+	amount, err := parseMoney(value("amount"), currency)
+	switch {
+	case err != nil:
+		errs["amount"] = fmt.Sprintf("Invalid amount: %s.", err.Error())
+	case amount.Currency != currency:
+		errs["amount"] = fmt.Sprintf("The claim is paid in %s.", currency)
+	case claimed.Currency != "" && amount.Value > claimed.Value:
+		errs["amount"] = fmt.Sprintf("At most %s can be paid.", claimed)
+	default:
+		payment.Amount = amount
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return payment, nil
+}
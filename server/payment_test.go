@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidatePayment(t *testing.T) {
+	claimed := Money{Value: 10000, Currency: "EUR"}
+	for name, tc := range map[string]struct {
+		submission     map[string]any
+		claimed        Money
+		expectedAmount Money
+		expectedErrs   []string
+	}{
+		"full payment": {
+			submission:     map[string]any{"date": "2024-03-01", "reference": "TX-1", "amount": "100.00"},
+			claimed:        claimed,
+			expectedAmount: claimed,
+		},
+		"partial payment": {
+			submission:     map[string]any{"date": "2024-03-01", "reference": "TX-1", "amount": "EUR 40"},
+			claimed:        claimed,
+			expectedAmount: Money{Value: 4000, Currency: "EUR"},
+		},
+		"legacy expense": {
+			submission:     map[string]any{"date": "2024-03-01", "reference": "TX-1", "amount": "55"},
+			expectedAmount: Money{Value: 5500, Currency: "EUR"},
+		},
+		"more than claimed": {
+			submission:   map[string]any{"date": "2024-03-01", "reference": "TX-1", "amount": "100.01"},
+			claimed:      claimed,
+			expectedErrs: []string{"amount"},
+		},
+		"other currency": {
+			submission:   map[string]any{"date": "2024-03-01", "reference": "TX-1", "amount": "USD 50"},
+			claimed:      claimed,
+			expectedErrs: []string{"amount"},
+		},
+		"missing fields": {
+			submission:   map[string]any{"date": "1 March", "amount": "50"},
+			claimed:      claimed,
+			expectedErrs: []string{"date", "reference"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			payment, errs := validatePayment(tc.submission, tc.claimed, "EUR")
+			if len(errs) != len(tc.expectedErrs) {
+				t.Logf("expected errors for %v, got %v", tc.expectedErrs, errs)
+				t.FailNow()
+			}
+			for _, field := range tc.expectedErrs {
+				if errs[field] == "" {
+					t.Logf("expected an error for %s, got %v", field, errs)
+					t.Fail()
+				}
+			}
+			if len(tc.expectedErrs) == 0 && payment.Amount != tc.expectedAmount {
+				t.Logf("expected %v, got %v", tc.expectedAmount, payment.Amount)
+				t.Fail()
+			}
+		})
+	}
+}
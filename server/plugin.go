@@ -3,10 +3,12 @@ package main
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 )
 
 // Plugin implements the interface expected by the Mattermost server to communicate between the server and plugin processes.
@@ -27,13 +29,22 @@ type Plugin struct {
 	// configuration is the active plugin configuration. Consult getConfiguration and
 	// setConfiguration for usage.
 	configuration *configuration
+
+	// reportJob posts the monthly report, reminderJob the reminders about stale claims and
+	// draftJob the nudges about abandoned drafts, on a single node of the cluster.
+	reportJob   *cluster.Job
+	reminderJob *cluster.Job
+	draftJob    *cluster.Job
 }
 
 // OnActivate is invoked when the plugin is activated. If an error is returned, the plugin will be deactivated.
 func (p *Plugin) OnActivate() error {
 	p.client = pluginapi.NewClient(p.API, p.Driver)
 
-	p.kvstore = NewKVStore(p.API)
+	p.kvstore = NewKVStore(p.API, func() time.Duration { return p.getConfiguration().draftExpiry })
+	if err := p.kvstore.Migrate(); err != nil {
+		return fmt.Errorf("failed to migrate KV store: %w", err)
+	}
 
 	botID, appErr := p.client.Bot.EnsureBot(&model.Bot{
 		Username:    "expensebot",
@@ -50,8 +61,28 @@ func (p *Plugin) OnActivate() error {
 	if err != nil {
 		return fmt.Errorf("failed to load plugin configuration: %w", err)
 	}
+	if err = config.prepare(); err != nil {
+		return fmt.Errorf("invalid plugin configuration: %w", err)
+	}
 	p.setConfiguration(config)
 
+	if err = p.registerCommands(); err != nil {
+		return err
+	}
+
+	p.reportJob, err = cluster.Schedule(p.API, "monthly_report", cluster.MakeWaitForInterval(reportCheckInterval), p.runMonthlyReport)
+	if err != nil {
+		return fmt.Errorf("failed to schedule monthly report: %w", err)
+	}
+	p.reminderJob, err = cluster.Schedule(p.API, "stale_reminders", cluster.MakeWaitForInterval(reminderCheckInterval), p.runReminders)
+	if err != nil {
+		return fmt.Errorf("failed to schedule reminders: %w", err)
+	}
+	p.draftJob, err = cluster.Schedule(p.API, "draft_expiry", cluster.MakeWaitForInterval(draftCheckInterval), p.runDraftExpiry)
+	if err != nil {
+		return fmt.Errorf("failed to schedule draft expiry: %w", err)
+	}
+
 	p.API.LogInfo("ExpenseBot plugin activated.")
 
 	return nil
@@ -59,5 +90,13 @@ func (p *Plugin) OnActivate() error {
 
 // OnDeactivate is invoked when the plugin is deactivated.
 func (p *Plugin) OnDeactivate() error {
+	for _, job := range []*cluster.Job{p.reportJob, p.reminderJob, p.draftJob} {
+		if job == nil {
+			continue
+		}
+		if err := job.Close(); err != nil {
+			p.API.LogError("failed to close job", "err", err.Error())
+		}
+	}
 	return nil
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExchangeRate is the value of one unit of From expressed in To, as published on Date.
+type ExchangeRate struct {
+	From string
+	To   string
+	Rate *big.Rat
+	Date string
+}
+
+// RateProvider looks up exchange rates.
+type RateProvider interface {
+	GetRate(from, to string) (*ExchangeRate, error)
+}
+
+// Conversion records how an expense amount was converted into the reimbursement currency.
+type Conversion struct {
+	Original Money  `json:"original"`
+	Rate     string `json:"rate"`
+	Date     string `json:"date"`
+}
+
+// convert converts amount into the currency of the rate, rounding half away from zero.
+func convert(amount Money, rate *ExchangeRate) (Money, error) {
+	if amount.Currency != rate.From {
+		return Money{}, errors.Errorf("rate is for %s, not %s", rate.From, amount.Currency)
+	}
+	value := new(big.Rat).SetInt64(amount.Value)
+	value.Mul(value, rate.Rate)
+	scale := new(big.Rat).SetFrac(pow10(currencyDecimals[rate.To]), pow10(currencyDecimals[rate.From]))
+	value.Mul(value, scale)
+
+	num, denom := value.Num(), value.Denom()
+	quotient, remainder := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if remainder.Mul(remainder, big.NewInt(2)).CmpAbs(denom) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(num.Sign())))
+	}
+	if !quotient.IsInt64() {
+		return Money{}, errors.New("converted amount out of range")
+	}
+	return Money{Value: quotient.Int64(), Currency: rate.To}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// convertAmount converts amount into the configured reimbursement currency. The conversion is nil
+// when the amount already is in the reimbursement currency.
+func (p *Plugin) convertAmount(amount Money) (Money, *Conversion, error) {
+	config := p.getConfiguration()
+	currency := config.reimbursementCurrency()
+	if amount.Currency == currency {
+		return amount, nil, nil
+	}
+	rate, err := config.rateProvider().GetRate(amount.Currency, currency)
+	if err != nil {
+		return Money{}, nil, err
+	}
+	converted, err := convert(amount, rate)
+	if err != nil {
+		return Money{}, nil, err
+	}
+	return converted, &Conversion{
+		Original: amount,
+		Rate:     strings.TrimRight(strings.TrimRight(rate.Rate.FloatString(6), "0"), "."),
+		Date:     rate.Date,
+	}, nil
+}
+
+// tableRateProvider looks up rates in the table configured by the administrator. Each line holds a
+// currency code and its value in the reimbursement currency, e.g. "USD 0.92" or "USD=0.92".
+type tableRateProvider struct {
+	table string
+	date  string
+}
+
+func (t tableRateProvider) GetRate(from, to string) (*ExchangeRate, error) {
+	for _, line := range strings.Split(t.table, "\n") {
+		fields := strings.Fields(strings.ReplaceAll(line, "=", " "))
+		if len(fields) != 2 || !strings.EqualFold(fields[0], from) {
+			continue
+		}
+		rate, ok := new(big.Rat).SetString(fields[1])
+		if !ok || rate.Sign() <= 0 {
+			return nil, errors.Errorf("invalid exchange rate %q for %s", fields[1], from)
+		}
+		return &ExchangeRate{From: from, To: to, Rate: rate, Date: t.date}, nil
+	}
+	return nil, errors.Errorf("no exchange rate configured for %s", from)
+}
+
+// httpRateProvider fetches rates from an HTTP service that answers GET <url>?from=USD&to=EUR with
+// {"date": "2024-01-31", "rates": {"EUR": 0.92}}, like the Frankfurter API.
+type httpRateProvider struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPRateProvider(rawURL string) httpRateProvider {
+	return httpRateProvider{
+		url:    rawURL,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (h httpRateProvider) GetRate(from, to string) (*ExchangeRate, error) {
+	u, err := url.Parse(h.url)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid exchange rate provider URL")
+	}
+	query := u.Query()
+	query.Set("from", from)
+	query.Set("to", to)
+	u.RawQuery = query.Encode()
+
+	resp, err := h.client.Get(u.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get exchange rate")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("exchange rate provider returned %s", resp.Status)
+	}
+
+	var body struct {
+		Date  string                 `json:"date"`
+		Rates map[string]json.Number `json:"rates"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "failed to decode exchange rate")
+	}
+	number, ok := body.Rates[to]
+	if !ok {
+		return nil, errors.Errorf("no exchange rate available for %s", from)
+	}
+	rate, ok := new(big.Rat).SetString(number.String())
+	if !ok || rate.Sign() <= 0 {
+		return nil, errors.Errorf("invalid exchange rate %q for %s", number, from)
+	}
+	return &ExchangeRate{From: from, To: to, Rate: rate, Date: body.Date}, nil
+}
+
+func (c *Conversion) String() string {
+	return fmt.Sprintf("%s at %s on %s", c.Original, c.Rate, c.Date)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	for name, tc := range map[string]struct {
+		amount   Money
+		rate     *ExchangeRate
+		expected Money
+	}{
+		"same decimals": {
+			amount:   Money{Value: 10000, Currency: "USD"},
+			rate:     &ExchangeRate{From: "USD", To: "EUR", Rate: big.NewRat(92, 100)},
+			expected: Money{Value: 9200, Currency: "EUR"},
+		},
+		"round half up": {
+			amount:   Money{Value: 5, Currency: "USD"},
+			rate:     &ExchangeRate{From: "USD", To: "EUR", Rate: big.NewRat(1, 2)},
+			expected: Money{Value: 3, Currency: "EUR"},
+		},
+		"from currency without minor unit": {
+			amount:   Money{Value: 1000, Currency: "JPY"},
+			rate:     &ExchangeRate{From: "JPY", To: "EUR", Rate: big.NewRat(6, 1000)},
+			expected: Money{Value: 600, Currency: "EUR"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			converted, err := convert(tc.amount, tc.rate)
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if converted != tc.expected {
+				t.Logf("expected %v, got %v", tc.expected, converted)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestTableRateProvider(t *testing.T) {
+	provider := tableRateProvider{table: "USD 0.92\nGBP=1.17\n", date: "2024-01-31"}
+
+	rate, err := provider.GetRate("GBP", "EUR")
+	if err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	if rate.Rate.Cmp(big.NewRat(117, 100)) != 0 || rate.Date != "2024-01-31" {
+		t.Logf("unexpected rate %v on %s", rate.Rate, rate.Date)
+		t.Fail()
+	}
+
+	if _, err = provider.GetRate("CHF", "EUR"); err == nil {
+		t.Logf("expected error for missing rate, got nil")
+		t.Fail()
+	}
+}
+
+func TestHTTPRateProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("from") != "USD" || r.URL.Query().Get("to") != "EUR" {
+			http.Error(w, "unknown currency", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"amount":1.0,"base":"USD","date":"2024-01-31","rates":{"EUR":0.9234}}`))
+	}))
+	defer server.Close()
+	provider := newHTTPRateProvider(server.URL)
+
+	rate, err := provider.GetRate("USD", "EUR")
+	if err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+	if rate.Rate.Cmp(big.NewRat(9234, 10000)) != 0 || rate.Date != "2024-01-31" {
+		t.Logf("unexpected rate %v on %s", rate.Rate, rate.Date)
+		t.Fail()
+	}
+
+	if _, err = provider.GetRate("GBP", "EUR"); err == nil {
+		t.Logf("expected error for unknown currency, got nil")
+		t.Fail()
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// expenseDialogState is passed through the reject and paid dialogs to their submission.
+type expenseDialogState struct {
+	ExpenseID string `json:"expense_id"`
+	PostID    string `json:"post_id"`
+}
+
+// openRejectDialog asks the user for the reason to reject the expense. It returns a message for
+// the user instead when they can't reject it.
+func (p *Plugin) openRejectDialog(triggerID, userID, expenseID, postID string) (string, error) {
+	expense, err := p.kvstore.GetExpense(expenseID)
+	if err != nil {
+		return "", err
+	}
+	if expense == nil {
+		return "This expense claim no longer exists.", nil
+	}
+	if message, checkErr := p.checkStateChange(userID, expense, ExpenseStateRejected); checkErr != nil || message != "" {
+		return message, checkErr
+	}
+
+	state, err := json.Marshal(expenseDialogState{ExpenseID: expenseID, PostID: postID})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal dialog state")
+	}
+	dialog := model.OpenDialogRequest{
+		TriggerId: triggerID,
+		URL:       pluginURL + "/api/dialogs/reject",
+		Dialog: model.Dialog{
+			CallbackId:       "reject",
+			Title:            "Reject expense claim",
+			IntroductionText: fmt.Sprintf("Rejecting the claim of **%s** for **%s**. The submitter will receive the reason.", expense.Amount, expense.Description),
+			SubmitLabel:      "Reject",
+			State:            string(state),
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Reason",
+					Name:        "reason",
+					Type:        "textarea",
+					HelpText:    "Why is the claim rejected, and what should be corrected?",
+					MaxLength:   1000,
+				},
+			},
+		},
+	}
+	if appErr := p.API.OpenInteractiveDialog(dialog); appErr != nil {
+		return "", errors.Wrap(appErr, "failed to open dialog")
+	}
+	return "", nil
+}
+
+func (p *Plugin) submitRejectDialog(request *model.SubmitDialogRequest) (*model.SubmitDialogResponse, error) {
+	var state expenseDialogState
+	if err := json.Unmarshal([]byte(request.State), &state); err != nil {
+		return nil, errors.Wrap(err, "failed to decode dialog state")
+	}
+	reason, _ := request.Submission["reason"].(string)
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return &model.SubmitDialogResponse{Errors: map[string]string{"reason": "Please give a reason."}}, nil
+	}
+
+	message, err := p.changeExpenseState(request.UserId, state.ExpenseID, ExpenseStateRejected, state.PostID, func(expense *Expense) {
+		expense.RejectionReason = reason
+	})
+	if err != nil {
+		return nil, err
+	}
+	if message != "" {
+		return &model.SubmitDialogResponse{Error: message}, nil
+	}
+	return &model.SubmitDialogResponse{}, nil
+}
+
+// resubmitExpense starts a new draft from a rejected expense of the user. It returns a message for
+// the user.
+func (p *Plugin) resubmitExpense(userID, expenseID string) (string, error) {
+	expense, err := p.kvstore.GetExpense(expenseID)
+	if err != nil {
+		return "", err
+	}
+	if expense == nil || expense.UserID != userID {
+		return "This expense claim no longer exists.", nil
+	}
+	if expense.State != ExpenseStateRejected {
+		return fmt.Sprintf("This expense claim is **%s**, only rejected claims can be resubmitted.", expense.State), nil
+	}
+	draft, err := p.getActiveDraft(userID)
+	if err != nil {
+		return "", err
+	}
+	if draft != nil {
+		return "You already have an expense claim in progress. Finish it, or type ```reset``` to discard it, and try again.", nil
+	}
+
+	draft = draftFromExpense(expense)
+	draft.State = DraftStateAskItems
+	if err = p.kvstore.SaveDraft(userID, draft); err != nil {
+		return "", err
+	}
+	items, err := p.formatItems(draft.Items, p.getConfiguration().reimbursementCurrency())
+	if err != nil {
+		return "", err
+	}
+	_ = p.sendDM(userID, "I copied your rejected claim into a new one, let's correct it. If you change your mind, type ```reset```.")
+	_ = p.sendDM(userID, items+"\nAdd an item as ```amount; description; category```, type ```remove <number>``` to remove one, or ```done``` when the items are correct.")
+	return "", nil
+}
+
+// draftFromExpense returns a draft holding the data of the expense.
+func draftFromExpense(expense *Expense) *Draft {
+	draft := &Draft{
+		UserID: expense.UserID,
+		Data: map[string]string{
+			"iban":        expense.Account,
+			"name":        expense.Name,
+			"description": expense.Description,
+		},
+		Items:   slices.Clone(expense.Items),
+		FileIDs: slices.Clone(expense.FileIDs),
+	}
+	if expense.Category != "" {
+		draft.Data["category"] = expense.Category
+	}
+	maps.DeleteFunc(draft.Data, func(_ string, value string) bool { return value == "" })
+	return draft
+}
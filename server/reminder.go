@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// reminderCheckInterval is how often the reminder job looks for stale claims.
+const reminderCheckInterval = time.Hour
+
+const (
+	reminderLevelReminded  = 1
+	reminderLevelEscalated = 2
+)
+
+// reminderLevel returns the reminder level a claim created at createAt should have reached by now.
+func reminderLevel(createAt int64, now time.Time, reminderDays, escalationDays int) int {
+	age := now.Sub(time.UnixMilli(createAt))
+	day := 24 * time.Hour
+	switch {
+	case escalationDays > 0 && age >= time.Duration(escalationDays)*day:
+		return reminderLevelEscalated
+	case reminderDays > 0 && age >= time.Duration(reminderDays)*day:
+		return reminderLevelReminded
+	}
+	return 0
+}
+
+// runReminders reminds the approvers of the claims that are submitted for too long, and escalates
+// the ones submitted for even longer.
+func (p *Plugin) runReminders() {
+	config := p.getConfiguration()
+	if config.reminderDays == 0 && config.escalationDays == 0 {
+		return
+	}
+	expenses, err := p.allExpenses(ExpenseFilter{State: ExpenseStateSubmitted})
+	if err != nil {
+		p.API.LogError("failed to list submitted expenses", "err", err.Error())
+		return
+	}
+	now := time.Now()
+	for _, expense := range expenses {
+		level := reminderLevel(expense.CreateAt, now, config.reminderDays, config.escalationDays)
+		if expense.CreateAt == 0 || level == 0 {
+			continue
+		}
+		previous, err := p.kvstore.GetReminderLevel(expense.ID)
+		if err != nil {
+			p.API.LogError("failed to get expense reminder", "id", expense.ID, "err", err.Error())
+			continue
+		}
+		if level <= previous {
+			continue
+		}
+		// Save first, so a failure can't make the reminder repeat every hour
+		ok, err := p.kvstore.SaveReminderLevel(expense.ID, previous, level)
+		if err != nil {
+			p.API.LogError("failed to save expense reminder", "id", expense.ID, "err", err.Error())
+			continue
+		}
+		if !ok {
+			// Another server sent it
+			continue
+		}
+		if err = p.remind(expense, level, previous); err != nil {
+			p.API.LogError("failed to remind about expense", "id", expense.ID, "err", err.Error())
+		}
+	}
+}
+
+// remind posts the reminder or escalation of the expense in its approval thread and sends it to
+// the people concerned. level is the reminder level the expense reached, previous the one it had.
+func (p *Plugin) remind(expense *Expense, level, previous int) error {
+	config := p.getConfiguration()
+	approvers := parseMemberList(config.Approvers)
+	if category := config.getCategory(expense.Category); category != nil && category.Approver != "" {
+		approvers = append(approvers, parseMemberList(category.Approver)...)
+	}
+	days := int(time.Since(time.UnixMilli(expense.CreateAt)).Hours() / 24)
+
+	var message string
+	var recipients []string
+	if level == reminderLevelEscalated {
+		contacts := parseMemberList(config.EscalationContacts)
+		message = fmt.Sprintf(":rotating_light: This expense claim of **%s** for **%s** is waiting for approval for %d days. %sPlease follow up.",
+			expense.Amount, expense.Description, days, mentions(contacts))
+		recipients = contacts
+		if previous < reminderLevelReminded && config.RemindApproversByDM {
+			recipients = append(recipients, approvers...)
+		}
+	} else {
+		message = fmt.Sprintf(":alarm_clock: Reminder: this expense claim of **%s** for **%s** is waiting for approval for %d days. %s",
+			expense.Amount, expense.Description, days, strings.TrimSpace(mentions(approvers)))
+		if config.RemindApproversByDM {
+			recipients = approvers
+		}
+	}
+	message = strings.TrimSpace(message)
+
+	var link string
+	if expense.ChannelPostID != "" {
+		root, appErr := p.API.GetPost(expense.ChannelPostID)
+		if appErr != nil {
+			return errors.Wrap(appErr, "failed to get post")
+		}
+		if _, appErr = p.API.CreatePost(&model.Post{
+			UserId:    p.botID,
+			ChannelId: root.ChannelId,
+			RootId:    root.Id,
+			Message:   message,
+		}); appErr != nil {
+			return errors.Wrap(appErr, "failed to create post")
+		}
+		link = fmt.Sprintf("\n\n[Open the claim](%s/_redirect/pl/%s)", p.getBaseURL(), root.Id)
+	}
+	for _, user := range p.resolveMembers(recipients) {
+		if user.Id == expense.UserID || user.IsBot {
+			continue
+		}
+		_ = p.sendDM(user.Id, message+link)
+	}
+	return nil
+}
+
+// mentions returns the @mentions of the names, each followed by a space.
+func mentions(names []string) string {
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString("@" + name + " ")
+	}
+	return sb.String()
+}
+
+// resolveMembers returns the users in the list of usernames and group names, without duplicates.
+func (p *Plugin) resolveMembers(names []string) []*model.User {
+	seen := map[string]bool{}
+	var users []*model.User
+	addUser := func(user *model.User) {
+		if !seen[user.Id] {
+			seen[user.Id] = true
+			users = append(users, user)
+		}
+	}
+	for _, name := range names {
+		if user, appErr := p.API.GetUserByUsername(name); appErr == nil {
+			addUser(user)
+			continue
+		}
+		group, appErr := p.API.GetGroupByName(name)
+		if appErr != nil {
+			p.API.LogWarn("unknown user or group", "name", name)
+			continue
+		}
+		for page := 0; ; page++ {
+			members, appErr := p.API.GetGroupMemberUsers(group.Id, page, 100)
+			if appErr != nil {
+				p.API.LogWarn("failed to get group members", "group", name, "err", appErr.Error())
+				break
+			}
+			for _, member := range members {
+				addUser(member)
+			}
+			if len(members) < 100 {
+				break
+			}
+		}
+	}
+	return users
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReminderLevel(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days float64) int64 {
+		return now.Add(-time.Duration(days * float64(24*time.Hour))).UnixMilli()
+	}
+	for name, tc := range map[string]struct {
+		createAt       int64
+		reminderDays   int
+		escalationDays int
+		expected       int
+	}{
+		"fresh":               {createAt: daysAgo(1), reminderDays: 3, escalationDays: 7, expected: 0},
+		"due for reminder":    {createAt: daysAgo(3), reminderDays: 3, escalationDays: 7, expected: reminderLevelReminded},
+		"due for escalation":  {createAt: daysAgo(7.5), reminderDays: 3, escalationDays: 7, expected: reminderLevelEscalated},
+		"reminders disabled":  {createAt: daysAgo(5), escalationDays: 7, expected: 0},
+		"escalation disabled": {createAt: daysAgo(30), reminderDays: 3, expected: reminderLevelReminded},
+		"escalation only":     {createAt: daysAgo(8), escalationDays: 7, expected: reminderLevelEscalated},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := reminderLevel(tc.createAt, now, tc.reminderDays, tc.escalationDays); actual != tc.expected {
+				t.Logf("expected level %d, got %d", tc.expected, actual)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestSaveReminderLevel(t *testing.T) {
+	kv, _, data := newTestStore()
+	for _, step := range []struct {
+		previous int
+		level    int
+		expected bool
+	}{
+		{previous: 0, level: reminderLevelReminded, expected: true},
+		{previous: 0, level: reminderLevelReminded, expected: false},
+		{previous: reminderLevelReminded, level: reminderLevelEscalated, expected: true},
+		{previous: reminderLevelReminded, level: reminderLevelEscalated, expected: false},
+	} {
+		ok, err := kv.SaveReminderLevel("expense", step.previous, step.level)
+		if err != nil {
+			t.Logf("expected no error, got %v", err)
+			t.FailNow()
+		}
+		if ok != step.expected {
+			t.Logf("expected saving %d over %d to be %v, got %v", step.level, step.previous, step.expected, ok)
+			t.Fail()
+		}
+	}
+	level, err := kv.GetReminderLevel("expense")
+	if err != nil || level != reminderLevelEscalated {
+		t.Logf("expected level %d, got %d, %v", reminderLevelEscalated, level, err)
+		t.Fail()
+	}
+	if _, ok := data["expense:expense"]; ok {
+		t.Logf("expected the expense to be left alone")
+		t.Fail()
+	}
+}
+
+func TestPrepareReminderDays(t *testing.T) {
+	for name, tc := range map[string]struct {
+		reminderDays   string
+		escalationDays string
+		expectedErr    bool
+	}{
+		"both":                 {reminderDays: "3", escalationDays: "7"},
+		"reminder only":        {reminderDays: "3"},
+		"escalation only":      {escalationDays: "7"},
+		"escalation same day":  {reminderDays: "3", escalationDays: "3", expectedErr: true},
+		"escalation before":    {reminderDays: "7", escalationDays: "3", expectedErr: true},
+		"invalid reminder day": {reminderDays: "0", expectedErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := &configuration{ReminderDays: tc.reminderDays, EscalationDays: tc.escalationDays}
+			if err := config.prepare(); (err != nil) != tc.expectedErr {
+				t.Logf("expected error %v, got %v", tc.expectedErr, err)
+				t.Fail()
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// reportCheckInterval is how often the report job checks whether a monthly report is due.
+const reportCheckInterval = time.Hour
+
+// reportTotal counts expenses and sums their amounts by currency.
+type reportTotal struct {
+	count   int
+	amounts map[string]int64
+}
+
+func (t *reportTotal) add(expense *Expense) {
+	t.count++
+	if expense.Amount.Currency == "" {
+		return
+	}
+	if t.amounts == nil {
+		t.amounts = map[string]int64{}
+	}
+	t.amounts[expense.Amount.Currency] += expense.Amount.Value
+}
+
+func (t *reportTotal) String() string {
+	currencies := make([]string, 0, len(t.amounts))
+	for currency := range t.amounts {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	amounts := make([]string, 0, len(currencies))
+	for _, currency := range currencies {
+		amounts = append(amounts, Money{Value: t.amounts[currency], Currency: currency}.String())
+	}
+	return strings.Join(amounts, ", ")
+}
+
+// reportMonth returns the start of the month before the one of now, and the start of the month of
+// now.
+func reportMonth(now time.Time) (time.Time, time.Time) {
+	end := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return end.AddDate(0, -1, 0), end
+}
+
+// runMonthlyReport posts the report of the previous month when it is due and was not posted yet.
+func (p *Plugin) runMonthlyReport() {
+	day := p.getConfiguration().reportDay
+	now := time.Now()
+	if day == 0 || now.Day() < day {
+		return
+	}
+	start, _ := reportMonth(now)
+	month := start.Format(monthFormat)
+	last, err := p.kvstore.GetLastReport()
+	if err != nil {
+		p.API.LogError("failed to get last monthly report", "err", err.Error())
+		return
+	}
+	if last >= month {
+		return
+	}
+	if err = p.postMonthlyReport(now); err != nil {
+		p.API.LogError("failed to post monthly report", "month", month, "err", err.Error())
+		return
+	}
+	if err = p.kvstore.SaveLastReport(month); err != nil {
+		p.API.LogError("failed to save last monthly report", "err", err.Error())
+	}
+}
+
+// postMonthlyReport posts the report of the month before now to the finance channel, with the
+// claims of the month attached as CSV.
+func (p *Plugin) postMonthlyReport(now time.Time) error {
+	config := p.getConfiguration()
+	channelID := config.FinanceChannelID
+	if channelID == "" {
+		channelID = config.ChannelID
+	}
+	start, end := reportMonth(now)
+	request := &exportRequest{filter: ExpenseFilter{From: start, To: end}, format: exportFormatCSV}
+	expenses, err := p.allExpenses(request.filter)
+	if err != nil {
+		return err
+	}
+	outstanding, err := p.allExpenses(ExpenseFilter{State: ExpenseStateSubmitted})
+	if err != nil {
+		return err
+	}
+
+	usernames := map[string]string{}
+	username := func(userID string) string {
+		if _, ok := usernames[userID]; !ok {
+			usernames[userID] = userID
+			if user, appErr := p.API.GetUser(userID); appErr == nil {
+				usernames[userID] = "@" + user.Username
+			}
+		}
+		return usernames[userID]
+	}
+	message := formatMonthlyReport(start, expenses, outstanding, username, now)
+
+	var buf bytes.Buffer
+	if err = p.writeExport(&buf, request); err != nil {
+		return err
+	}
+	fileInfo, appErr := p.API.UploadFile(buf.Bytes(), channelID, fmt.Sprintf("expenses-%s.csv", start.Format(monthFormat)))
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to upload report")
+	}
+	if _, appErr = p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: channelID,
+		Message:   message,
+		FileIds:   []string{fileInfo.Id},
+	}); appErr != nil {
+		return errors.Wrap(appErr, "failed to create post")
+	}
+	return nil
+}
+
+// formatMonthlyReport renders the report of the expenses created in the month starting at start,
+// and of the outstanding submitted expenses, oldest first.
+func formatMonthlyReport(start time.Time, expenses, outstanding []*Expense, username func(userID string) string, now time.Time) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("### Expense report for %s\n\n", start.Format("January 2006")))
+	if len(expenses) == 0 {
+		sb.WriteString("No expense claims were submitted.\n")
+	} else {
+		byState := map[string]*reportTotal{}
+		byUser := map[string]*reportTotal{}
+		byCategory := map[string]*reportTotal{}
+		all := &reportTotal{}
+		for _, expense := range expenses {
+			for _, group := range []struct {
+				totals map[string]*reportTotal
+				key    string
+			}{{byState, expense.State}, {byUser, username(expense.UserID)}, {byCategory, expense.Category}} {
+				if group.totals[group.key] == nil {
+					group.totals[group.key] = &reportTotal{}
+				}
+				group.totals[group.key].add(expense)
+			}
+			all.add(expense)
+		}
+		sb.WriteString(fmt.Sprintf("%d expense claims totalling **%s**.\n", all.count, all))
+		writeReportTotals(&sb, "State", byState)
+		writeReportTotals(&sb, "User", byUser)
+		writeReportTotals(&sb, "Category", byCategory)
+	}
+
+	sb.WriteString("\n#### Outstanding claims\n\n")
+	if len(outstanding) == 0 {
+		sb.WriteString("There are no submitted claims waiting for approval.\n")
+		return sb.String()
+	}
+	outstanding = append([]*Expense(nil), outstanding...)
+	sort.Slice(outstanding, func(i, j int) bool { return outstanding[i].CreateAt < outstanding[j].CreateAt })
+	sb.WriteString("|Submitted|Age|ID|User|Amount|Description|\n|-|-|-|-|-|-|\n")
+	for _, expense := range outstanding {
+		created := time.UnixMilli(expense.CreateAt)
+		age := int(now.Sub(created).Hours() / 24)
+		sb.WriteString(fmt.Sprintf("|%s|%d days|%s|%s|%s|%s|\n",
+			created.Format(time.DateOnly), age, expense.ID, username(expense.UserID), expense.Amount, expense.Description))
+	}
+	return sb.String()
+}
+
+// writeReportTotals writes a table of the totals, by name.
+func writeReportTotals(sb *strings.Builder, title string, totals map[string]*reportTotal) {
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sb.WriteString(fmt.Sprintf("\n|%s|Claims|Total|\n|-|-|-|\n", title))
+	for _, name := range names {
+		label := name
+		if label == "" {
+			label = "None"
+		}
+		sb.WriteString(fmt.Sprintf("|%s|%d|%s|\n", label, totals[name].count, totals[name]))
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportMonth(t *testing.T) {
+	start, end := reportMonth(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	if !start.Equal(time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Logf("unexpected month %s to %s", start, end)
+		t.Fail()
+	}
+}
+
+func TestFormatMonthlyReport(t *testing.T) {
+	now := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	start, _ := reportMonth(now)
+	expenses := []*Expense{
+		{ID: "a", UserID: "alice", State: ExpenseStatePaid, Category: "Travel", Amount: Money{Value: 10000, Currency: "EUR"}},
+		{ID: "b", UserID: "alice", State: ExpenseStateSubmitted, Category: "Meals", Amount: Money{Value: 2550, Currency: "EUR"}, CreateAt: time.Date(2024, 2, 20, 9, 0, 0, 0, time.UTC).UnixMilli()},
+		{ID: "c", UserID: "bob", State: ExpenseStatePaid, Amount: Money{Value: 500, Currency: "EUR"}},
+	}
+	outstanding := []*Expense{expenses[1], {ID: "d", UserID: "bob", State: ExpenseStateSubmitted, Amount: Money{Value: 100, Currency: "EUR"}, CreateAt: time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC).UnixMilli()}}
+	report := formatMonthlyReport(start, expenses, outstanding, func(userID string) string { return "@" + userID }, now)
+
+	for _, expected := range []string{
+		"### Expense report for February 2024",
+		"3 expense claims totalling **EUR 130.50**.",
+		"|Paid|2|EUR 105.00|",
+		"|Submitted|1|EUR 25.50|",
+		"|@alice|2|EUR 125.50|",
+		"|None|1|EUR 5.00|",
+		"|2024-01-31|30 days|d|@bob|EUR 1.00||\n|2024-02-20|10 days|b|",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Logf("expected %q in report:\n%s", expected, report)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/almerlucke/go-iban/iban"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// sepaCurrency is the only currency SEPA credit transfers can be made in.
+const sepaCurrency = "EUR"
+
+// sepaDocument is a SEPA credit transfer initiation, pain.001.001.03, with a single payment.
+type sepaDocument struct {
+	XMLName xml.Name `xml:"urn:iso:std:iso:20022:tech:xsd:pain.001.001.03 Document"`
+	GrpHdr  struct {
+		MsgID    string `xml:"MsgId"`
+		CreDtTm  string `xml:"CreDtTm"`
+		NbOfTxs  int    `xml:"NbOfTxs"`
+		CtrlSum  string `xml:"CtrlSum"`
+		InitgPty struct {
+			Nm string `xml:"Nm"`
+		} `xml:"InitgPty"`
+	} `xml:"CstmrCdtTrfInitn>GrpHdr"`
+	PmtInf struct {
+		PmtInfID  string `xml:"PmtInfId"`
+		PmtMtd    string `xml:"PmtMtd"`
+		BtchBookg bool   `xml:"BtchBookg"`
+		NbOfTxs   int    `xml:"NbOfTxs"`
+		CtrlSum   string `xml:"CtrlSum"`
+		SvcLvlCd  string `xml:"PmtTpInf>SvcLvl>Cd"`
+		ReqdExctn string `xml:"ReqdExctnDt"`
+		DbtrNm    string `xml:"Dbtr>Nm"`
+		DbtrIBAN  string `xml:"DbtrAcct>Id>IBAN"`
+		DbtrAgt   struct {
+			BIC    string `xml:"BIC,omitempty"`
+			OthrID string `xml:"Othr>Id,omitempty"`
+		} `xml:"DbtrAgt>FinInstnId"`
+		ChrgBr    string         `xml:"ChrgBr"`
+		CdtTrfTxs []sepaTransfer `xml:"CdtTrfTxInf"`
+	} `xml:"CstmrCdtTrfInitn>PmtInf"`
+}
+
+// sepaTransfer is a single credit transfer of a SEPA document.
+type sepaTransfer struct {
+	EndToEndID string `xml:"PmtId>EndToEndId"`
+	Amount     struct {
+		Ccy   string `xml:"Ccy,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"Amt>InstdAmt"`
+	CdtrNm   string `xml:"Cdtr>Nm"`
+	CdtrIBAN string `xml:"CdtrAcct>Id>IBAN"`
+	Ustrd    string `xml:"RmtInf>Ustrd"`
+}
+
+// sepaDebtor is the account a payment batch is paid from.
+type sepaDebtor struct {
+	Name string
+	IBAN string
+	BIC  string
+}
+
+// debtor returns the configured debtor account, or an error describing what is wrong with it.
+func (c *configuration) debtor() (*sepaDebtor, error) {
+	if strings.TrimSpace(c.DebtorName) == "" || strings.TrimSpace(c.DebtorIBAN) == "" {
+		return nil, errors.New("the debtor name and IBAN are not configured")
+	}
+	account, err := iban.NewIBAN(c.DebtorIBAN)
+	if err != nil {
+		return nil, errors.Wrap(err, "the debtor IBAN is invalid")
+	}
+	return &sepaDebtor{
+		Name: strings.TrimSpace(c.DebtorName),
+		IBAN: account.Code,
+		BIC:  strings.ToUpper(strings.TrimSpace(c.DebtorBIC)),
+	}, nil
+}
+
+// sepaText replaces the characters SEPA does not allow, and truncates the text to max characters.
+func sepaText(text string, max int) string {
+	text = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("/-?:().,'+ ", r):
+			return r
+		}
+		return ' '
+	}, text)
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > max {
+		text = strings.TrimSpace(text[:max])
+	}
+	return text
+}
+
+// sepaTransferable tells why the expense can't be paid by SEPA credit transfer, or returns an empty
+// string when it can.
+func sepaTransferable(expense *Expense) string {
+	if expense.Amount.Currency != sepaCurrency {
+		return "not in " + sepaCurrency
+	}
+	if _, err := iban.NewIBAN(expense.Account); err != nil {
+		return "invalid IBAN"
+	}
+	if sepaText(expense.Name, 70) == "" {
+		return "no account holder"
+	}
+	return ""
+}
+
+// buildSEPADocument returns the pain.001.001.03 XML paying the expenses from the debtor account.
+func buildSEPADocument(batchID string, debtor *sepaDebtor, expenses []*Expense, now time.Time) ([]byte, error) {
+	total := Money{Currency: sepaCurrency}
+	var doc sepaDocument
+	for _, expense := range expenses {
+		account, err := iban.NewIBAN(expense.Account)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid IBAN for expense %s", expense.ID)
+		}
+		description := expense.Description
+		if description == "" {
+			description = "Expense claim"
+		}
+		transfer := sepaTransfer{
+			EndToEndID: expense.ID,
+			CdtrNm:     sepaText(expense.Name, 70),
+			CdtrIBAN:   account.Code,
+			Ustrd:      sepaText(fmt.Sprintf("%s %s", expense.ID, description), 140),
+		}
+		transfer.Amount.Ccy = sepaCurrency
+		transfer.Amount.Value = expense.Amount.Decimal()
+		doc.PmtInf.CdtTrfTxs = append(doc.PmtInf.CdtTrfTxs, transfer)
+		total.Value += expense.Amount.Value
+	}
+
+	doc.GrpHdr.MsgID = batchID
+	doc.GrpHdr.CreDtTm = now.UTC().Format("2006-01-02T15:04:05")
+	doc.GrpHdr.NbOfTxs = len(expenses)
+	doc.GrpHdr.CtrlSum = total.Decimal()
+	doc.GrpHdr.InitgPty.Nm = sepaText(debtor.Name, 70)
+	doc.PmtInf.PmtInfID = batchID
+	doc.PmtInf.PmtMtd = "TRF"
+	doc.PmtInf.BtchBookg = true
+	doc.PmtInf.NbOfTxs = len(expenses)
+	doc.PmtInf.CtrlSum = total.Decimal()
+	doc.PmtInf.SvcLvlCd = "SEPA"
+	doc.PmtInf.ReqdExctn = now.Format(time.DateOnly)
+	doc.PmtInf.DbtrNm = sepaText(debtor.Name, 70)
+	doc.PmtInf.DbtrIBAN = debtor.IBAN
+	if debtor.BIC != "" {
+		doc.PmtInf.DbtrAgt.BIC = debtor.BIC
+	} else {
+		doc.PmtInf.DbtrAgt.OthrID = "NOTPROVIDED"
+	}
+	doc.PmtInf.ChrgBr = "SLEV"
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return nil, errors.Wrap(err, "failed to encode SEPA document")
+	}
+	return buf.Bytes(), nil
+}
+
+// createPaymentBatch collects the approved expenses that are not in a payment batch yet, marks them
+// as in a new batch and posts the SEPA file paying them to the finance channel. It returns a
+// message for the user.
+func (p *Plugin) createPaymentBatch(userID string) (string, error) {
+	if message, err := p.authorizePayment(userID); err != nil || message != "" {
+		return message, err
+	}
+	config := p.getConfiguration()
+	debtor, err := config.debtor()
+	if err != nil {
+		return fmt.Sprintf("Can't create a payment batch, %s. Ask your administrator to fix the plugin settings.", err.Error()), nil
+	}
+	channelID := config.FinanceChannelID
+	if channelID == "" {
+		channelID = config.ChannelID
+	}
+
+	expenses, err := p.allExpenses(ExpenseFilter{State: ExpenseStateApproved})
+	if err != nil {
+		return "", err
+	}
+	batchID := model.NewId()
+	var batch []*Expense
+	var skipped []string
+	for _, expense := range expenses {
+		if expense.PaymentBatchID != "" {
+			continue
+		}
+		if reason := sepaTransferable(expense); reason != "" {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", expense.ID, reason))
+			continue
+		}
+		expense.PaymentBatchID = batchID
+		if err = p.kvstore.SaveExpense(expense); err != nil {
+			if errors.Is(err, ErrExpenseConflict) {
+				// Changed since it was listed, it goes in the next batch if it's still approved
+				skipped = append(skipped, fmt.Sprintf("%s (changed meanwhile)", expense.ID))
+				continue
+			}
+			p.releasePaymentBatch(batch)
+			return "", err
+		}
+		batch = append(batch, expense)
+	}
+	if len(batch) == 0 {
+		message := "There are no approved expense claims to pay."
+		if len(skipped) > 0 {
+			message += " Skipped: " + strings.Join(skipped, ", ") + "."
+		}
+		return message, nil
+	}
+
+	now := time.Now()
+	data, err := buildSEPADocument(batchID, debtor, batch, now)
+	if err != nil {
+		p.releasePaymentBatch(batch)
+		return "", err
+	}
+	fileInfo, appErr := p.API.UploadFile(data, channelID, fmt.Sprintf("sepa-%s-%s.xml", now.Format("20060102"), batchID))
+	if appErr != nil {
+		p.releasePaymentBatch(batch)
+		return "", errors.Wrap(appErr, "failed to upload SEPA file")
+	}
+	total := Money{Currency: sepaCurrency}
+	var sb strings.Builder
+	sb.WriteString("|ID|Name|Amount|Description|\n|-|-|-|-|\n")
+	for _, expense := range batch {
+		total.Value += expense.Amount.Value
+		sb.WriteString(fmt.Sprintf("|%s|%s|%s|%s|\n", expense.ID, expense.Name, expense.Amount, expense.Description))
+	}
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.releasePaymentBatch(batch)
+		return "", errors.Wrap(appErr, "failed to get user")
+	}
+	if _, appErr = p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: channelID,
+		Message: fmt.Sprintf("**SEPA payment batch %s** of %d claims totalling **%s**, created by @%s. Mark each claim paid once the bank executed the transfers.\n\n%s",
+			batchID, len(batch), total, user.Username, sb.String()),
+		FileIds: []string{fileInfo.Id},
+	}); appErr != nil {
+		p.releasePaymentBatch(batch)
+		return "", errors.Wrap(appErr, "failed to create post")
+	}
+
+	for _, expense := range batch {
+		if err = p.updateUser(expense); err != nil {
+			p.API.LogError("failed to update user post", "err", err.Error())
+		}
+		if err = p.updateChannel(expense, ""); err != nil {
+			p.API.LogError("failed to update channel post", "err", err.Error())
+		}
+	}
+	message := fmt.Sprintf("Payment batch **%s** with %d claims is posted in ~%s.", batchID, len(batch), p.channelName(channelID))
+	if len(skipped) > 0 {
+		message += " Skipped: " + strings.Join(skipped, ", ") + "."
+	}
+	return message, nil
+}
+
+// releasePaymentBatch takes the expenses out of a batch that could not be created.
+func (p *Plugin) releasePaymentBatch(batch []*Expense) {
+	for _, expense := range batch {
+		expense.PaymentBatchID = ""
+		if err := p.kvstore.SaveExpense(expense); err != nil {
+			p.API.LogError("failed to release expense from payment batch", "id", expense.ID, "err", err.Error())
+		}
+	}
+}
+
+// channelName returns the name of the channel, or its ID when it can't be found.
+func (p *Plugin) channelName(channelID string) string {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return channelID
+	}
+	return channel.Name
+}
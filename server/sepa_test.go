@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSEPAText(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input    string
+		max      int
+		expected string
+	}{
+		"allowed":      {input: "Lunch (client), 12/03", max: 140, expected: "Lunch (client), 12/03"},
+		"replaced":     {input: "Café & bar", max: 140, expected: "Caf bar"},
+		"truncated":    {input: "Train ticket", max: 5, expected: "Train"},
+		"only invalid": {input: "€€", max: 140, expected: ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := sepaText(tc.input, tc.max); actual != tc.expected {
+				t.Logf("expected %q, got %q", tc.expected, actual)
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestBuildSEPADocument(t *testing.T) {
+	debtor := &sepaDebtor{Name: "Example Ltd", IBAN: "DE89370400440532013000"}
+	expenses := []*Expense{
+		{ID: "expense1", Account: "NL91 ABNA 0417 1643 00", Name: "Alice", Amount: Money{Value: 1250, Currency: "EUR"}, Description: "Lunch"},
+		{ID: "expense2", Account: "DE89370400440532013000", Name: "Bob", Amount: Money{Value: 100000, Currency: "EUR"}},
+	}
+	data, err := buildSEPADocument("batch1", debtor, expenses, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Logf("expected no error, got %v", err)
+		t.FailNow()
+	}
+
+	var doc sepaDocument
+	if err = xml.Unmarshal(data, &doc); err != nil {
+		t.Logf("expected valid XML, got %v", err)
+		t.FailNow()
+	}
+	if doc.GrpHdr.NbOfTxs != 2 || doc.GrpHdr.CtrlSum != "1012.50" || doc.PmtInf.CtrlSum != "1012.50" {
+		t.Logf("unexpected group header: %+v", doc.GrpHdr)
+		t.Fail()
+	}
+	if doc.PmtInf.ReqdExctn != "2024-03-01" || doc.PmtInf.DbtrIBAN != debtor.IBAN || doc.PmtInf.DbtrAgt.OthrID != "NOTPROVIDED" {
+		t.Logf("unexpected payment information: %+v", doc.PmtInf)
+		t.Fail()
+	}
+	if len(doc.PmtInf.CdtTrfTxs) != 2 {
+		t.Logf("expected 2 transfers, got %d", len(doc.PmtInf.CdtTrfTxs))
+		t.FailNow()
+	}
+	transfer := doc.PmtInf.CdtTrfTxs[0]
+	if transfer.EndToEndID != "expense1" || transfer.CdtrIBAN != "NL91ABNA0417164300" || transfer.Amount.Value != "12.50" || transfer.Amount.Ccy != "EUR" || transfer.Ustrd != "expense1 Lunch" {
+		t.Logf("unexpected transfer: %+v", transfer)
+		t.Fail()
+	}
+	if doc.PmtInf.CdtTrfTxs[1].Ustrd != "expense2 Expense claim" {
+		t.Logf("unexpected remittance information: %s", doc.PmtInf.CdtTrfTxs[1].Ustrd)
+		t.Fail()
+	}
+	if !strings.Contains(string(data), `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:pain.001.001.03">`) {
+		t.Logf("expected the pain.001.001.03 namespace, got %s", data)
+		t.Fail()
+	}
+}
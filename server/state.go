@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/pkg/errors"
+)
+
+// expenseTransitions lists the states an expense can move to from each state. States without
+// transitions are final.
+var expenseTransitions = map[string][]string{
+	ExpenseStateSubmitted: {ExpenseStateApproved, ExpenseStateRejected, ExpenseStateCancelled},
+	ExpenseStateApproved:  {ExpenseStatePaid, ExpenseStateRejected},
+	ExpenseStatePaid:      {},
+	ExpenseStateRejected:  {},
+	ExpenseStateCancelled: {},
+}
+
+var (
+	// ErrUnknownState is returned for a state that is not in the transition table.
+	ErrUnknownState = errors.New("unknown expense state")
+
+	// ErrIllegalTransition is returned when the expense can't move from its state to the new one.
+	ErrIllegalTransition = errors.New("illegal expense state transition")
+)
+
+// Transition records a change of state of an expense.
+type Transition struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	UserID   string `json:"user_id"`
+	CreateAt int64  `json:"create_at"`
+}
+
+func isExpenseState(state string) bool {
+	_, ok := expenseTransitions[state]
+	return ok
+}
+
+// isFinalState tells whether the expense can't leave the state anymore.
+func isFinalState(state string) bool {
+	transitions, ok := expenseTransitions[state]
+	return ok && len(transitions) == 0
+}
+
+// transition moves the expense to the state on behalf of the user and records it in the history.
+func (e *Expense) transition(to string, userID string) error {
+	if !isExpenseState(to) {
+		return errors.Wrap(ErrUnknownState, to)
+	}
+	if !slices.Contains(expenseTransitions[e.State], to) {
+		return errors.Wrapf(ErrIllegalTransition, "%s to %s", e.State, to)
+	}
+	e.History = append(e.History, Transition{
+		From:     e.State,
+		To:       to,
+		UserID:   userID,
+		CreateAt: model.GetMillis(),
+	})
+	e.State = to
+	return nil
+}
+
+// checkStateChange returns why the user can't move the expense to the state, or an empty string
+// when they can.
+func (p *Plugin) checkStateChange(userID string, expense *Expense, state string) (string, error) {
+	if expense.State == state {
+		// Repeated click, the post may not have been updated yet
+		return fmt.Sprintf("This expense claim is already **%s**.", state), nil
+	}
+	if reason, err := p.authorizeTransition(userID, expense, state); err != nil || reason != "" {
+		if reason != "" {
+			p.API.LogWarn("Unauthorized expense update", "id", expense.ID, "state", state, "user_id", userID)
+		}
+		return reason, err
+	}
+	if !slices.Contains(expenseTransitions[expense.State], state) {
+		return fmt.Sprintf("This expense claim is **%s** and can't be changed to **%s**.", expense.State, state), nil
+	}
+	return "", nil
+}
+
+// changeExpenseState moves the expense to the state on behalf of the user, applying update to the
+// expense before it is saved, and updates its posts. requestPostID is the post the change was
+// made from. It returns a message for the user when the change was not made.
+func (p *Plugin) changeExpenseState(userID, expenseID, state, requestPostID string, update func(expense *Expense)) (string, error) {
+	expense, err := p.kvstore.GetExpense(expenseID)
+	if err != nil {
+		return "", err
+	}
+	if expense == nil {
+		return "This expense claim no longer exists.", nil
+	}
+	if message, checkErr := p.checkStateChange(userID, expense, state); checkErr != nil || message != "" {
+		return message, checkErr
+	}
+	if err = expense.transition(state, userID); err != nil {
+		return "", err
+	}
+	if update != nil {
+		update(expense)
+	}
+	if expense.State == ExpenseStateApproved {
+		if err = p.sendFinanceMessage(expense); err != nil {
+			return "", err
+		}
+	}
+	if err = p.kvstore.SaveExpense(expense); err != nil {
+		if expense.FinancePostID != "" && expense.State == ExpenseStateApproved {
+			_ = p.API.DeletePost(expense.FinancePostID)
+		}
+		if errors.Is(err, ErrExpenseConflict) {
+			return p.conflictMessage(expenseID), nil
+		}
+		return "", err
+	}
+	if err = p.updateUser(expense); err != nil {
+		p.API.LogError("failed to update user post", "err", err.Error())
+	}
+	if err = p.updateChannel(expense, requestPostID); err != nil {
+		p.API.LogError("failed to update channel post", "err", err.Error())
+	}
+	p.notifyStateChange(expense, userID)
+	return "", nil
+}
+
+// conflictMessage tells the user that someone else changed the expense first, and what its state
+// is now.
+func (p *Plugin) conflictMessage(expenseID string) string {
+	if current, err := p.kvstore.GetExpense(expenseID); err == nil && current != nil {
+		return fmt.Sprintf("Someone else changed this expense claim at the same time. It is now **%s**.", current.State)
+	}
+	return "Someone else changed this expense claim at the same time, please try again."
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestExpenseTransition(t *testing.T) {
+	for name, tc := range map[string]struct {
+		from        string
+		to          string
+		expectedErr error
+	}{
+		"submitted to approved":  {from: ExpenseStateSubmitted, to: ExpenseStateApproved},
+		"submitted to rejected":  {from: ExpenseStateSubmitted, to: ExpenseStateRejected},
+		"submitted to paid":      {from: ExpenseStateSubmitted, to: ExpenseStatePaid, expectedErr: ErrIllegalTransition},
+		"approved to paid":       {from: ExpenseStateApproved, to: ExpenseStatePaid},
+		"approved to rejected":   {from: ExpenseStateApproved, to: ExpenseStateRejected},
+		"submitted to cancelled": {from: ExpenseStateSubmitted, to: ExpenseStateCancelled},
+		"approved to cancelled":  {from: ExpenseStateApproved, to: ExpenseStateCancelled, expectedErr: ErrIllegalTransition},
+		"rejected to paid":       {from: ExpenseStateRejected, to: ExpenseStatePaid, expectedErr: ErrIllegalTransition},
+		"paid to paid":           {from: ExpenseStatePaid, to: ExpenseStatePaid, expectedErr: ErrIllegalTransition},
+		"unknown state":          {from: ExpenseStateSubmitted, to: "Approved!", expectedErr: ErrUnknownState},
+	} {
+		t.Run(name, func(t *testing.T) {
+			expense := &Expense{State: tc.from}
+			err := expense.transition(tc.to, "user")
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Logf("expected %v, got %v", tc.expectedErr, err)
+					t.Fail()
+				}
+				if expense.State != tc.from || len(expense.History) != 0 {
+					t.Logf("expected expense to be unchanged, got state %s", expense.State)
+					t.Fail()
+				}
+				return
+			}
+			if err != nil {
+				t.Logf("expected no error, got %v", err)
+				t.FailNow()
+			}
+			if expense.State != tc.to || len(expense.History) != 1 || expense.History[0].From != tc.from || expense.History[0].UserID != "user" {
+				t.Logf("unexpected expense after transition: %+v", expense)
+				t.Fail()
+			}
+		})
+	}
+}